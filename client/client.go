@@ -1,23 +1,147 @@
 package client
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"replay-demo/codec"
+	"replay-demo/config"
+	"replay-demo/logging"
 
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
 )
 
-func NewClient() client.Client {
-	return newLocalClient()
+// NewClient connects to the Temporal cluster and namespace of cfg.
+func NewClient(cfg config.Config) (client.Client, error) {
+	return NewClientWithTelemetry(cfg, Telemetry{})
+}
+
+// Telemetry is what a client, and the workers it runs, report on the workflows and activities.
+type Telemetry struct {
+	// Logger writes the SDK's lines, and those of the workflows and activities, which carry the update ID and request
+	// ID of logging.WithRequestID. Defaults to the SDK's logger.
+	Logger *slog.Logger
+	// MetricsHandler records the SDK's metrics, and those of the workflows and activities.
+	MetricsHandler client.MetricsHandler
+	// Interceptors trace the workflows, updates and activities, see tracing.NewInterceptors.
+	Interceptors []interceptor.ClientInterceptor
 }
 
-func GetNamespace() string {
-	return "default"
+// NewClientWithTelemetry connects like NewClient, reporting to telemetry.
+func NewClientWithTelemetry(cfg config.Config, telemetry Telemetry) (client.Client, error) {
+	options, err := Options(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if telemetry.Logger != nil {
+		options.Logger = logging.NewTemporalLogger(telemetry.Logger)
+	}
+	options.MetricsHandler = telemetry.MetricsHandler
+	options.Interceptors = append([]interceptor.ClientInterceptor{logging.NewInterceptor()}, telemetry.Interceptors...)
+	options.ContextPropagators = []workflow.ContextPropagator{logging.NewContextPropagator()}
+	c, err := client.Dial(options)
+	if err != nil {
+		return nil, fmt.Errorf("connect to Temporal at %v (namespace %v): %w", cfg.HostPort, cfg.Namespace, err)
+	}
+	return c, nil
 }
 
-func newLocalClient() client.Client {
-	c, err := client.Dial(client.Options{})
+// Options are the client options for the cluster of cfg, securing the connection with TLS and an API key, and
+// encrypting payloads, when cfg sets them.
+func Options(cfg config.Config) (client.Options, error) {
+	options := client.Options{
+		HostPort:  cfg.HostPort,
+		Namespace: cfg.Namespace,
+	}
+	if cfg.UseTLS() {
+		tlsConfig, err := newTLSConfig(cfg)
+		if err != nil {
+			return client.Options{}, err
+		}
+		options.ConnectionOptions.TLS = tlsConfig
+	}
+	if cfg.APIKey != "" {
+		options.HeadersProvider = apiKeyHeaders{apiKey: cfg.APIKey, namespace: cfg.Namespace}
+	}
+	payloadCodec, err := NewCodec(cfg)
 	if err != nil {
-		log.Fatalln("Unable to create client", err)
+		return client.Options{}, err
 	}
-	return c
+	if payloadCodec != nil {
+		options.DataConverter = converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), payloadCodec)
+		// error messages name accounts and amounts too
+		options.FailureConverter = temporal.NewDefaultFailureConverter(temporal.DefaultFailureConverterOptions{
+			DataConverter:          options.DataConverter,
+			EncodeCommonAttributes: true,
+		})
+	}
+	return options, nil
+}
+
+// NewCodec is the codec encrypting payloads with the keys of cfg, or nil when cfg has none.
+func NewCodec(cfg config.Config) (converter.PayloadCodec, error) {
+	if len(cfg.EncryptionKeys) == 0 {
+		return nil, nil
+	}
+	keys := make(map[string][]byte, len(cfg.EncryptionKeys))
+	for id, key := range cfg.EncryptionKeys {
+		var err error
+		if keys[id], err = base64.StdEncoding.DecodeString(key); err != nil {
+			return nil, fmt.Errorf("invalid encryption key (%v): %w", id, err)
+		}
+	}
+	payloadCodec, err := codec.NewAESCodec(keys, cfg.EncryptionKeyID)
+	if err != nil {
+		return nil, err
+	}
+	return payloadCodec, nil
+}
+
+func newTLSConfig(cfg config.Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.TLSServerName,
+	}
+	if cfg.TLSCertPath != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCertPath, cfg.TLSKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("load TLS client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if cfg.TLSCAPath != "" {
+		pem, err := os.ReadFile(cfg.TLSCAPath)
+		if err != nil {
+			return nil, fmt.Errorf("load TLS CA: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("load TLS CA: no certificate found in " + cfg.TLSCAPath)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// apiKeyHeaders authenticates every request with an API key.
+type apiKeyHeaders struct {
+	apiKey    string
+	namespace string
+}
+
+func (h apiKeyHeaders) GetHeaders(ctx context.Context) (map[string]string, error) {
+	return map[string]string{
+		"authorization": "Bearer " + h.apiKey,
+		// routes the request to the namespace before it is authorized
+		"temporal-namespace": h.namespace,
+	}, nil
 }
@@ -0,0 +1,220 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/converter"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	demo "replay-demo/client"
+	"replay-demo/codec"
+	"replay-demo/config"
+)
+
+// certs are PEM files of a test CA, and of a server and a client certificate it signed.
+type certs struct {
+	caPath, serverCertPath, serverKeyPath, clientCertPath, clientKeyPath string
+}
+
+func newCerts(t *testing.T) certs {
+	dir := t.TempDir()
+	writePEM := func(name, typ string, der []byte) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+		return path
+	}
+	issue := func(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*ecdsa.PrivateKey, []byte) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		if parentKey == nil {
+			parent, parentKey = template, key
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+		require.NoError(t, err)
+		return key, der
+	}
+	writeKey := func(name string, key *ecdsa.PrivateKey) string {
+		der, err := x509.MarshalECPrivateKey(key)
+		require.NoError(t, err)
+		return writePEM(name, "EC PRIVATE KEY", der)
+	}
+
+	notAfter := time.Now().Add(time.Hour)
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caKey, caDER := issue(ca, nil, nil)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	serverKey, serverDER := issue(&x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "temporal.test"},
+		DNSNames:     []string{"temporal.test"},
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	clientKey, clientDER := issue(&x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "demo-worker"},
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	return certs{
+		caPath:         writePEM("ca.pem", "CERTIFICATE", caDER),
+		serverCertPath: writePEM("server.pem", "CERTIFICATE", serverDER),
+		serverKeyPath:  writeKey("server.key", serverKey),
+		clientCertPath: writePEM("client.pem", "CERTIFICATE", clientDER),
+		clientKeyPath:  writeKey("client.key", clientKey),
+	}
+}
+
+// frontend stands in for a Temporal frontend behind mTLS, recording who connected and with which headers.
+type frontend struct {
+	workflowservice.UnimplementedWorkflowServiceServer
+
+	mu         sync.Mutex
+	clientName string
+	headers    metadata.MD
+}
+
+func (f *frontend) GetSystemInfo(ctx context.Context, req *workflowservice.GetSystemInfoRequest) (*workflowservice.GetSystemInfoResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.headers, _ = metadata.FromIncomingContext(ctx)
+	if p, ok := peer.FromContext(ctx); ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+			f.clientName = info.State.PeerCertificates[0].Subject.CommonName
+		}
+	}
+	return &workflowservice.GetSystemInfoResponse{}, nil
+}
+
+func startFrontend(t *testing.T, certs certs) (*frontend, string) {
+	cert, err := tls.LoadX509KeyPair(certs.serverCertPath, certs.serverKeyPath)
+	require.NoError(t, err)
+	caPEM, err := os.ReadFile(certs.caPath)
+	require.NoError(t, err)
+	clientCAs := x509.NewCertPool()
+	require.True(t, clientCAs.AppendCertsFromPEM(caPEM))
+
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})))
+	f := &frontend{}
+	workflowservice.RegisterWorkflowServiceServer(server, f)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return f, lis.Addr().String()
+}
+
+func TestNewClient_MTLSAndAPIKey(t *testing.T) {
+	certs := newCerts(t)
+	f, hostPort := startFrontend(t, certs)
+
+	c, err := demo.NewClient(config.Config{
+		HostPort:      hostPort,
+		Namespace:     "payments",
+		TLSCertPath:   certs.clientCertPath,
+		TLSKeyPath:    certs.clientKeyPath,
+		TLSCAPath:     certs.caPath,
+		TLSServerName: "temporal.test",
+		APIKey:        "secret",
+	})
+	require.NoError(t, err)
+	defer c.Close()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	require.Equal(t, "demo-worker", f.clientName)
+	require.Equal(t, []string{"Bearer secret"}, f.headers.Get("authorization"))
+	require.Equal(t, []string{"payments"}, f.headers.Get("temporal-namespace"))
+}
+
+func TestOptions_EncryptPayloads(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	options, err := demo.Options(config.Config{
+		HostPort:        "localhost:7233",
+		Namespace:       "default",
+		EncryptionKeys:  map[string]string{"2023-09": key},
+		EncryptionKeyID: "2023-09",
+	})
+	require.NoError(t, err)
+
+	payload, err := options.DataConverter.ToPayload("alice")
+	require.NoError(t, err)
+	require.Equal(t, codec.MetadataEncodingEncrypted, string(payload.Metadata[converter.MetadataEncoding]))
+	var name string
+	require.NoError(t, options.DataConverter.FromPayload(payload, &name))
+	require.Equal(t, "alice", name)
+
+	// error messages are encrypted along with the payloads
+	failure := options.FailureConverter.ErrorToFailure(errors.New("account alice is frozen"))
+	require.NotContains(t, failure.GetMessage(), "alice")
+	require.EqualError(t, options.FailureConverter.FailureToError(failure), "account alice is frozen")
+
+	_, err = demo.Options(config.Config{EncryptionKeys: map[string]string{"2023-09": "not base64"}, EncryptionKeyID: "2023-09"})
+	require.ErrorContains(t, err, "invalid encryption key (2023-09)")
+}
+
+func TestNewClient_Errors(t *testing.T) {
+	certs := newCerts(t)
+	_, hostPort := startFrontend(t, certs)
+	cfg := config.Config{
+		HostPort:      hostPort,
+		Namespace:     "payments",
+		TLSCAPath:     certs.caPath,
+		TLSServerName: "temporal.test",
+	}
+
+	// the frontend wants a client certificate
+	_, err := demo.NewClient(cfg)
+	require.ErrorContains(t, err, "connect to Temporal at "+hostPort+" (namespace payments)")
+
+	// the frontend's certificate isn't signed by a system root
+	withCert := cfg
+	withCert.TLSCertPath, withCert.TLSKeyPath = certs.clientCertPath, certs.clientKeyPath
+	withCert.TLSCAPath = ""
+	_, err = demo.NewClient(withCert)
+	require.ErrorContains(t, err, "certificate")
+
+	withCert.TLSKeyPath = filepath.Join(t.TempDir(), "missing.key")
+	_, err = demo.NewClient(withCert)
+	require.ErrorContains(t, err, "load TLS client certificate")
+
+	withCA := cfg
+	withCA.TLSCAPath = certs.clientKeyPath
+	_, err = demo.NewClient(withCA)
+	require.ErrorContains(t, err, "load TLS CA: no certificate found")
+}
@@ -0,0 +1,20 @@
+package codec
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// RequireToken serves handler only to requests carrying token as a bearer token in their Authorization header, as the
+// temporal CLI sends it with --codec-auth "Bearer <token>". Anyone able to call the codec server can decrypt any
+// payload with it.
+func RequireToken(token string, handler http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
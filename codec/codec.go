@@ -0,0 +1,103 @@
+package codec
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/converter"
+)
+
+const (
+	// MetadataEncodingEncrypted is the encoding of the payloads encrypted by AESCodec.
+	MetadataEncodingEncrypted = "binary/encrypted"
+	// MetadataEncryptionKeyID is the metadata holding the ID of the key a payload was encrypted with.
+	MetadataEncryptionKeyID = "encryption-key-id"
+)
+
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// AESCodec encrypts payloads with AES-GCM, so they are stored by Temporal as ciphertext. Payloads are encrypted with
+// the current key, and record its ID so they can be decrypted after the current key changed: to rotate keys, add the
+// new key, make it current and keep the old one until no history needs it. Payloads that aren't encrypted are decoded
+// as they are, so histories recorded before encryption stay readable.
+type AESCodec struct {
+	keyID string
+	aeads map[string]cipher.AEAD
+}
+
+var _ converter.PayloadCodec = (*AESCodec)(nil)
+
+// NewAESCodec makes a codec with the AES keys by ID, which must be 16, 24 or 32 bytes long, encrypting with the key of
+// keyID.
+func NewAESCodec(keys map[string][]byte, keyID string) (*AESCodec, error) {
+	if _, ok := keys[keyID]; !ok {
+		return nil, fmt.Errorf("%w (%v)", ErrUnknownKey, keyID)
+	}
+	c := &AESCodec{keyID: keyID, aeads: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid encryption key (%v): %w", id, err)
+		}
+		if c.aeads[id], err = cipher.NewGCM(block); err != nil {
+			return nil, fmt.Errorf("invalid encryption key (%v): %w", id, err)
+		}
+	}
+	return c, nil
+}
+
+func (c *AESCodec) Encode(payloads []*commonpb.Payload) ([]*commonpb.Payload, error) {
+	aead := c.aeads[c.keyID]
+	result := make([]*commonpb.Payload, len(payloads))
+	for i, p := range payloads {
+		plaintext, err := p.Marshal()
+		if err != nil {
+			return nil, err
+		}
+		nonce := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return nil, err
+		}
+		result[i] = &commonpb.Payload{
+			Metadata: map[string][]byte{
+				converter.MetadataEncoding: []byte(MetadataEncodingEncrypted),
+				MetadataEncryptionKeyID:    []byte(c.keyID),
+			},
+			// the key ID is authenticated along with the payload, so it can't be swapped
+			Data: aead.Seal(nonce, nonce, plaintext, []byte(c.keyID)),
+		}
+	}
+	return result, nil
+}
+
+func (c *AESCodec) Decode(payloads []*commonpb.Payload) ([]*commonpb.Payload, error) {
+	result := make([]*commonpb.Payload, len(payloads))
+	for i, p := range payloads {
+		if string(p.GetMetadata()[converter.MetadataEncoding]) != MetadataEncodingEncrypted {
+			result[i] = p
+			continue
+		}
+		keyID := string(p.GetMetadata()[MetadataEncryptionKeyID])
+		aead, ok := c.aeads[keyID]
+		if !ok {
+			return nil, fmt.Errorf("%w (%v)", ErrUnknownKey, keyID)
+		}
+		if len(p.Data) < aead.NonceSize() {
+			return nil, errors.New("decrypt payload: data too short")
+		}
+		nonce, ciphertext := p.Data[:aead.NonceSize()], p.Data[aead.NonceSize():]
+		plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(keyID))
+		if err != nil {
+			return nil, fmt.Errorf("decrypt payload with key %v: %w", keyID, err)
+		}
+		result[i] = &commonpb.Payload{}
+		if err := result[i].Unmarshal(plaintext); err != nil {
+			return nil, fmt.Errorf("decrypt payload with key %v: %w", keyID, err)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,120 @@
+package codec_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/converter"
+	"replay-demo/codec"
+	"replay-demo/money"
+	"replay-demo/workflows"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func toPayload(t *testing.T, value interface{}) *commonpb.Payload {
+	payload, err := converter.GetDefaultDataConverter().ToPayload(value)
+	require.NoError(t, err)
+	return payload
+}
+
+func TestAESCodec_RoundTrip(t *testing.T) {
+	c, err := codec.NewAESCodec(map[string][]byte{"2023-09": oldKey}, "2023-09")
+	require.NoError(t, err)
+	req := workflows.TransferRequest{FromAccount: "alice", ToAccount: "bob", Amount: 42 * money.Unit}
+
+	encoded, err := c.Encode([]*commonpb.Payload{toPayload(t, req)})
+	require.NoError(t, err)
+	require.Equal(t, codec.MetadataEncodingEncrypted, string(encoded[0].Metadata[converter.MetadataEncoding]))
+	require.Equal(t, "2023-09", string(encoded[0].Metadata[codec.MetadataEncryptionKeyID]))
+	require.NotContains(t, string(encoded[0].Data), "alice")
+
+	decoded, err := c.Decode(encoded)
+	require.NoError(t, err)
+	var got workflows.TransferRequest
+	require.NoError(t, converter.GetDefaultDataConverter().FromPayload(decoded[0], &got))
+	require.Equal(t, req, got)
+}
+
+func TestAESCodec_KeyRotation(t *testing.T) {
+	before, err := codec.NewAESCodec(map[string][]byte{"2023-09": oldKey}, "2023-09")
+	require.NoError(t, err)
+	encodedBefore, err := before.Encode([]*commonpb.Payload{toPayload(t, "alice")})
+	require.NoError(t, err)
+
+	// the new key is current, and the old one still decodes what was encrypted with it
+	after, err := codec.NewAESCodec(map[string][]byte{"2023-09": oldKey, "2023-10": newKey}, "2023-10")
+	require.NoError(t, err)
+	encodedAfter, err := after.Encode([]*commonpb.Payload{toPayload(t, "bob")})
+	require.NoError(t, err)
+	require.Equal(t, "2023-10", string(encodedAfter[0].Metadata[codec.MetadataEncryptionKeyID]))
+
+	plain := toPayload(t, "carol")
+	decoded, err := after.Decode([]*commonpb.Payload{encodedBefore[0], encodedAfter[0], plain})
+	require.NoError(t, err)
+	var names []string
+	for _, p := range decoded {
+		var name string
+		require.NoError(t, converter.GetDefaultDataConverter().FromPayload(p, &name))
+		names = append(names, name)
+	}
+	require.Equal(t, []string{"alice", "bob", "carol"}, names)
+
+	// once the old key is dropped, its payloads can't be read anymore
+	retired, err := codec.NewAESCodec(map[string][]byte{"2023-10": newKey}, "2023-10")
+	require.NoError(t, err)
+	_, err = retired.Decode(encodedBefore)
+	require.ErrorIs(t, err, codec.ErrUnknownKey)
+}
+
+func TestAESCodec_Errors(t *testing.T) {
+	_, err := codec.NewAESCodec(map[string][]byte{"2023-09": oldKey}, "2023-10")
+	require.ErrorIs(t, err, codec.ErrUnknownKey)
+	_, err = codec.NewAESCodec(map[string][]byte{"2023-09": []byte("too short")}, "2023-09")
+	require.ErrorContains(t, err, "invalid encryption key (2023-09)")
+
+	c, err := codec.NewAESCodec(map[string][]byte{"2023-09": oldKey}, "2023-09")
+	require.NoError(t, err)
+	encoded, err := c.Encode([]*commonpb.Payload{toPayload(t, "alice")})
+	require.NoError(t, err)
+
+	tampered := *encoded[0]
+	tampered.Data = append([]byte(nil), encoded[0].Data...)
+	tampered.Data[len(tampered.Data)-1] ^= 1
+	_, err = c.Decode([]*commonpb.Payload{&tampered})
+	require.ErrorContains(t, err, "decrypt payload with key 2023-09")
+
+	// a payload can't claim another key than the one it was encrypted with
+	rotated, err := codec.NewAESCodec(map[string][]byte{"2023-09": oldKey, "2023-10": oldKey}, "2023-10")
+	require.NoError(t, err)
+	relabeled := *encoded[0]
+	relabeled.Metadata = map[string][]byte{
+		converter.MetadataEncoding:    []byte(codec.MetadataEncodingEncrypted),
+		codec.MetadataEncryptionKeyID: []byte("2023-10"),
+	}
+	_, err = rotated.Decode([]*commonpb.Payload{&relabeled})
+	require.ErrorContains(t, err, "decrypt payload with key 2023-10")
+}
+
+func TestRequireToken(t *testing.T) {
+	handler := codec.RequireToken("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for header, code := range map[string]int{
+		"Bearer secret": http.StatusOK,
+		"Bearer other":  http.StatusUnauthorized,
+		"secret":        http.StatusUnauthorized,
+		"":              http.StatusUnauthorized,
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/encode", nil)
+		req.Header.Set("Authorization", header)
+		handler.ServeHTTP(rec, req)
+		require.Equal(t, code, rec.Code, header)
+	}
+}
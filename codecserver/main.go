@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/rs/cors"
+	"go.temporal.io/sdk/converter"
+	demo "replay-demo/client"
+	"replay-demo/codec"
+	"replay-demo/config"
+	"replay-demo/logging"
+)
+
+// The codec server decrypts payloads for the Temporal Web UI and the temporal CLI, which never see the encryption keys.
+// It only needs the keys, not a connection to Temporal. Anyone who can call it can decrypt payloads, so it listens on
+// localhost unless it requires a token.
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		logging.Fatal(slog.Default(), "Invalid config", "Error", err)
+	}
+	logger := logging.New(cfg)
+	payloadCodec, err := demo.NewCodec(cfg)
+	if err != nil {
+		logging.Fatal(logger, "Invalid encryption keys", "Error", err)
+	}
+	if payloadCodec == nil {
+		logging.Fatal(logger, "No encryption keys: set TEMPORAL_ENCRYPTION_KEYS")
+	}
+
+	var handler http.Handler = converter.NewPayloadCodecHTTPHandler(payloadCodec)
+	if cfg.CodecAuthToken != "" {
+		handler = codec.RequireToken(cfg.CodecAuthToken, handler)
+	} else if !cfg.CodecLocalOnly() {
+		logging.Fatal(logger, "No codec auth token: set CODEC_AUTH_TOKEN to listen beyond localhost", "Address", cfg.CodecAddr())
+	}
+
+	// The Web UI calls the codec server from the browser, so its origin must be allowed: CODEC_CORS_ORIGINS is a
+	// comma-separated list, defaulting to the UI of the local dev server.
+	handler = cors.New(cors.Options{
+		AllowedOrigins:   cfg.CodecCORSOrigins,
+		AllowedMethods:   []string{http.MethodPost},
+		AllowedHeaders:   []string{"Content-Type", "X-Namespace", "Authorization"},
+		AllowCredentials: true,
+	}).Handler(handler)
+
+	logger.Info("Starting codec server", "Address", cfg.CodecAddr())
+	if err := http.ListenAndServe(cfg.CodecAddr(), handler); err != nil {
+		logging.Fatal(logger, "Unable to serve", "Error", err)
+	}
+}
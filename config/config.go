@@ -0,0 +1,345 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/client"
+	"replay-demo/money"
+	"replay-demo/ratelimit"
+)
+
+const (
+	DefaultNamespace = "default"
+	DefaultTaskQueue = "demo-tq"
+	DefaultHTTPPort  = 7654
+	DefaultCodecPort = 8081
+	// DefaultCodecHost keeps the codec server, which decrypts any payload it is sent, to the local machine.
+	DefaultCodecHost = "localhost"
+	// DefaultMetricsPort stays clear of Prometheus' own 9090.
+	DefaultMetricsPort = 9099
+	// DefaultLedgerPath is relative to the directory the worker runs in.
+	DefaultLedgerPath = "ledger.jsonl"
+	// DefaultBankOpeningBalance is what every account of the in-memory bank opens with.
+	DefaultBankOpeningBalance = 1000 * money.Unit
+)
+
+// DefaultCodecCORSOrigins are the origins of the Web UI of the local dev server.
+var DefaultCodecCORSOrigins = []string{"http://localhost:8233", "http://127.0.0.1:8233"}
+
+// The banks the worker can move money with.
+const (
+	// BankGatewayStub accepts every transfer.
+	BankGatewayStub = "stub"
+	// BankGatewayMemory keeps balances in memory, opening every account with BankOpeningBalance.
+	BankGatewayMemory = "memory"
+)
+
+// The exporters spans can be sent to.
+const (
+	// TracesExporterNone drops spans. Traces still connect the worker and the HTTP server.
+	TracesExporterNone = "none"
+	// TracesExporterOTLP sends spans to an OTLP collector over gRPC, at OTEL_EXPORTER_OTLP_ENDPOINT
+	// (default localhost:4317).
+	TracesExporterOTLP = "otlp"
+	// TracesExporterStdout writes spans to stdout.
+	TracesExporterStdout = "stdout"
+)
+
+// Config is the deployment the worker, the HTTP server and the CLI run in. Deployments sharing a Temporal cluster are
+// kept apart by their namespace or task queue.
+type Config struct {
+	// HostPort is the address of the Temporal frontend. Defaults to client.DefaultHostPort.
+	HostPort string
+	// Namespace defaults to DefaultNamespace.
+	Namespace string
+	// TaskQueue is where workflows and activities are run. Defaults to DefaultTaskQueue.
+	TaskQueue string
+	// HTTPPort is where the HTTP server listens, serving its metrics as /metrics too. Defaults to DefaultHTTPPort.
+	HTTPPort int
+	// MetricsPort is where the worker serves its metrics, as /metrics. Defaults to DefaultMetricsPort.
+	MetricsPort int
+
+	// TLS connects to Temporal over TLS, checking its certificate against the system roots. It is implied by any other
+	// TLS setting and by APIKey.
+	TLS bool
+	// TLSCertPath and TLSKeyPath are the PEM files of the client certificate and key for mTLS.
+	TLSCertPath string
+	TLSKeyPath  string
+	// TLSCAPath is the PEM file of the CA Temporal's certificate is checked against, in place of the system roots.
+	TLSCAPath string
+	// TLSServerName is the name Temporal's certificate is checked for. Defaults to the host of HostPort.
+	TLSServerName string
+	// APIKey is sent to Temporal as a bearer token on every request.
+	APIKey string
+
+	// EncryptionKeys are the base64 AES keys payloads are encrypted with, by key ID. Payloads are stored in the clear
+	// when it is empty.
+	EncryptionKeys map[string]string
+	// EncryptionKeyID is the key new payloads are encrypted with. Defaults to the only key, when there is one.
+	EncryptionKeyID string
+	// CodecHost and CodecPort are where the codec server listens. They default to DefaultCodecHost and
+	// DefaultCodecPort; an empty CodecHost listens on every interface.
+	CodecHost string
+	CodecPort int
+	// CodecAuthToken is the bearer token the codec server requires in the Authorization header of every request. The
+	// codec server only listens beyond localhost when it is set.
+	CodecAuthToken string
+	// CodecCORSOrigins are the origins allowed to call the codec server from the browser, like the Web UI's. Defaults
+	// to DefaultCodecCORSOrigins.
+	CodecCORSOrigins []string
+
+	// BankGateway is the bank the worker moves money with: BankGatewayStub or BankGatewayMemory. Defaults to
+	// BankGatewayStub.
+	BankGateway string
+	// BankOpeningBalance is what every account of BankGatewayMemory opens with. Defaults to
+	// DefaultBankOpeningBalance.
+	BankOpeningBalance money.Amount
+	// BankRateLimits paces the worker's calls to the bank per account prefix, as read by ratelimit.ParseRules, e.g.
+	// "piggy=1,from-account=20,*=50". Calls aren't paced when it is empty.
+	BankRateLimits string
+	// LedgerPath is the file every movement of money is journaled to. Defaults to DefaultLedgerPath.
+	LedgerPath string
+	// TransferInactivityTimeout abandons the transfers started by the HTTP server after this long without user input
+	// (in nanoseconds in the file). Defaults to workflows.DefaultInactivityTimeout.
+	TransferInactivityTimeout time.Duration
+
+	// LogLevel is the least severe level logged, such as "debug" or "warn" (in JSON too). Defaults to info.
+	LogLevel slog.Level
+	// TracesExporter is where the spans of the worker and the HTTP server go: TracesExporterNone, TracesExporterOTLP
+	// or TracesExporterStdout. Defaults to TracesExporterNone.
+	TracesExporter string
+}
+
+// Load reads the config from the JSON file named by DEMO_CONFIG, if set, on top of the defaults. The TEMPORAL_ADDRESS,
+// TEMPORAL_NAMESPACE, TEMPORAL_TASK_QUEUE, HTTP_PORT, METRICS_PORT, TEMPORAL_TLS, TEMPORAL_TLS_CERT, TEMPORAL_TLS_KEY,
+// TEMPORAL_TLS_CA, TEMPORAL_TLS_SERVER_NAME, TEMPORAL_API_KEY, TEMPORAL_ENCRYPTION_KEYS (as "id=key,id=key"),
+// TEMPORAL_ENCRYPTION_KEY_ID, CODEC_SERVER_HOST, CODEC_SERVER_PORT, CODEC_AUTH_TOKEN, CODEC_CORS_ORIGINS (as
+// "origin,origin"), BANK_GATEWAY, BANK_OPENING_BALANCE, BANK_RATE_LIMITS, LEDGER_PATH, TRANSFER_INACTIVITY_TIMEOUT
+// (as "5m"), LOG_LEVEL and OTEL_TRACES_EXPORTER env vars override the file when set.
+func Load() (Config, error) {
+	cfg := Config{
+		HostPort:    client.DefaultHostPort,
+		Namespace:   DefaultNamespace,
+		TaskQueue:   DefaultTaskQueue,
+		HTTPPort:    DefaultHTTPPort,
+		MetricsPort: DefaultMetricsPort,
+		CodecHost:   DefaultCodecHost,
+		CodecPort:   DefaultCodecPort,
+
+		// copied, as the file's origins are decoded into it
+		CodecCORSOrigins:   append([]string(nil), DefaultCodecCORSOrigins...),
+		BankGateway:        BankGatewayStub,
+		BankOpeningBalance: DefaultBankOpeningBalance,
+		LedgerPath:         DefaultLedgerPath,
+
+		TracesExporter: TracesExporterNone,
+	}
+	if path := os.Getenv("DEMO_CONFIG"); path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return Config{}, fmt.Errorf("read config %v: %w", path, err)
+		}
+	}
+
+	if v := os.Getenv("TEMPORAL_ADDRESS"); v != "" {
+		cfg.HostPort = v
+	}
+	if v := os.Getenv("TEMPORAL_NAMESPACE"); v != "" {
+		cfg.Namespace = v
+	}
+	if v := os.Getenv("TEMPORAL_TASK_QUEUE"); v != "" {
+		cfg.TaskQueue = v
+	}
+	if v := os.Getenv("HTTP_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid HTTP_PORT (%v): %w", v, err)
+		}
+		cfg.HTTPPort = port
+	}
+	if v := os.Getenv("METRICS_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid METRICS_PORT (%v): %w", v, err)
+		}
+		cfg.MetricsPort = port
+	}
+	if v := os.Getenv("CODEC_SERVER_PORT"); v != "" {
+		port, err := strconv.Atoi(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid CODEC_SERVER_PORT (%v): %w", v, err)
+		}
+		cfg.CodecPort = port
+	}
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := cfg.LogLevel.UnmarshalText([]byte(v)); err != nil {
+			return Config{}, fmt.Errorf("invalid LOG_LEVEL (%v): %w", v, err)
+		}
+	}
+	if v := os.Getenv("BANK_OPENING_BALANCE"); v != "" {
+		balance, err := money.Parse(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid BANK_OPENING_BALANCE (%v): %w", v, err)
+		}
+		cfg.BankOpeningBalance = balance
+	}
+	if v := os.Getenv("TRANSFER_INACTIVITY_TIMEOUT"); v != "" {
+		timeout, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid TRANSFER_INACTIVITY_TIMEOUT (%v): %w", v, err)
+		}
+		cfg.TransferInactivityTimeout = timeout
+	}
+	if v := os.Getenv("TEMPORAL_TLS"); v != "" {
+		tls, err := strconv.ParseBool(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid TEMPORAL_TLS (%v): %w", v, err)
+		}
+		cfg.TLS = tls
+	}
+	for name, field := range map[string]*string{
+		"TEMPORAL_TLS_CERT":          &cfg.TLSCertPath,
+		"TEMPORAL_TLS_KEY":           &cfg.TLSKeyPath,
+		"TEMPORAL_TLS_CA":            &cfg.TLSCAPath,
+		"TEMPORAL_TLS_SERVER_NAME":   &cfg.TLSServerName,
+		"TEMPORAL_API_KEY":           &cfg.APIKey,
+		"TEMPORAL_ENCRYPTION_KEY_ID": &cfg.EncryptionKeyID,
+		"CODEC_SERVER_HOST":          &cfg.CodecHost,
+		"CODEC_AUTH_TOKEN":           &cfg.CodecAuthToken,
+		"BANK_GATEWAY":               &cfg.BankGateway,
+		"BANK_RATE_LIMITS":           &cfg.BankRateLimits,
+		"LEDGER_PATH":                &cfg.LedgerPath,
+		"OTEL_TRACES_EXPORTER":       &cfg.TracesExporter,
+	} {
+		if v := os.Getenv(name); v != "" {
+			*field = v
+		}
+	}
+	if v := os.Getenv("CODEC_CORS_ORIGINS"); v != "" {
+		cfg.CodecCORSOrigins = nil
+		for _, origin := range strings.Split(v, ",") {
+			cfg.CodecCORSOrigins = append(cfg.CodecCORSOrigins, strings.TrimSpace(origin))
+		}
+	}
+	if v := os.Getenv("TEMPORAL_ENCRYPTION_KEYS"); v != "" {
+		cfg.EncryptionKeys = make(map[string]string)
+		for _, pair := range strings.Split(v, ",") {
+			id, key, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || id == "" {
+				return Config{}, fmt.Errorf("invalid TEMPORAL_ENCRYPTION_KEYS: want id=key pairs")
+			}
+			// base64 keys may end with = padding, which the cut leaves with the key
+			cfg.EncryptionKeys[id] = key
+		}
+	}
+	if cfg.EncryptionKeyID == "" && len(cfg.EncryptionKeys) == 1 {
+		for id := range cfg.EncryptionKeys {
+			cfg.EncryptionKeyID = id
+		}
+	}
+	return cfg, cfg.validate()
+}
+
+// UseTLS tells whether the connection to Temporal is secured.
+func (c Config) UseTLS() bool {
+	return c.TLS || c.TLSCertPath != "" || c.TLSKeyPath != "" || c.TLSCAPath != "" || c.TLSServerName != "" ||
+		c.APIKey != ""
+}
+
+// HTTPAddr is the address the HTTP server listens on.
+func (c Config) HTTPAddr() string {
+	return fmt.Sprintf(":%d", c.HTTPPort)
+}
+
+// MetricsAddr is the address the worker serves its metrics on.
+func (c Config) MetricsAddr() string {
+	return fmt.Sprintf(":%d", c.MetricsPort)
+}
+
+// CodecAddr is the address the codec server listens on.
+func (c Config) CodecAddr() string {
+	return net.JoinHostPort(c.CodecHost, strconv.Itoa(c.CodecPort))
+}
+
+// CodecLocalOnly tells whether only the local machine can reach the codec server.
+func (c Config) CodecLocalOnly() bool {
+	if c.CodecHost == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(c.CodecHost)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (c *Config) readFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(c)
+}
+
+func (c Config) validate() error {
+	switch {
+	case c.HostPort == "":
+		return errors.New("host port is not set")
+	case c.Namespace == "":
+		return errors.New("namespace is not set")
+	case c.TaskQueue == "":
+		return errors.New("task queue is not set")
+	case c.HTTPPort <= 0 || c.HTTPPort > 65535:
+		return fmt.Errorf("invalid HTTP port (%v)", c.HTTPPort)
+	case c.MetricsPort <= 0 || c.MetricsPort > 65535:
+		return fmt.Errorf("invalid metrics port (%v)", c.MetricsPort)
+	case c.CodecPort <= 0 || c.CodecPort > 65535:
+		return fmt.Errorf("invalid codec server port (%v)", c.CodecPort)
+	case (c.TLSCertPath == "") != (c.TLSKeyPath == ""):
+		return errors.New("TLS cert and key must be set together")
+	case len(c.EncryptionKeys) > 0 && c.EncryptionKeys[c.EncryptionKeyID] == "":
+		return fmt.Errorf("encryption key ID (%v) is not one of the encryption keys", c.EncryptionKeyID)
+	case c.TracesExporter != TracesExporterNone && c.TracesExporter != TracesExporterOTLP &&
+		c.TracesExporter != TracesExporterStdout:
+		return fmt.Errorf("unknown traces exporter (%v)", c.TracesExporter)
+	case c.BankGateway != BankGatewayStub && c.BankGateway != BankGatewayMemory:
+		return fmt.Errorf("unknown bank gateway (%v)", c.BankGateway)
+	case c.BankOpeningBalance < 0:
+		return fmt.Errorf("invalid bank opening balance (%v): must not be negative", c.BankOpeningBalance)
+	case c.LedgerPath == "":
+		return errors.New("ledger path is not set")
+	case c.TransferInactivityTimeout < 0:
+		return fmt.Errorf("invalid transfer inactivity timeout (%v): must not be negative", c.TransferInactivityTimeout)
+	}
+	if _, err := ratelimit.ParseRules(c.BankRateLimits); err != nil {
+		return fmt.Errorf("invalid bank rate limits: %w", err)
+	}
+	for _, origin := range c.CodecCORSOrigins {
+		if err := validateOrigin(origin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateOrigin checks that origin is "*" or a scheme and a host, like "https://ui.example.com:8233", as browsers
+// send it in the Origin header.
+func validateOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+		(u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("invalid codec CORS origin (%v): want scheme://host[:port]", origin)
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package config_test
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"replay-demo/config"
+	"replay-demo/money"
+)
+
+// clearEnv unsets the config env vars for the test, so the environment running the tests doesn't leak in.
+func clearEnv(t *testing.T) {
+	for _, name := range []string{
+		"DEMO_CONFIG", "TEMPORAL_ADDRESS", "TEMPORAL_NAMESPACE", "TEMPORAL_TASK_QUEUE", "HTTP_PORT", "METRICS_PORT",
+		"TEMPORAL_TLS", "TEMPORAL_TLS_CERT", "TEMPORAL_TLS_KEY", "TEMPORAL_TLS_CA", "TEMPORAL_TLS_SERVER_NAME",
+		"TEMPORAL_API_KEY", "TEMPORAL_ENCRYPTION_KEYS", "TEMPORAL_ENCRYPTION_KEY_ID", "CODEC_SERVER_HOST",
+		"CODEC_SERVER_PORT", "CODEC_AUTH_TOKEN", "CODEC_CORS_ORIGINS", "BANK_GATEWAY", "BANK_OPENING_BALANCE",
+		"BANK_RATE_LIMITS", "LEDGER_PATH", "TRANSFER_INACTIVITY_TIMEOUT", "LOG_LEVEL", "OTEL_TRACES_EXPORTER",
+	} {
+		t.Setenv(name, "")
+	}
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	clearEnv(t)
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	require.Equal(t, config.Config{
+		HostPort:    "localhost:7233",
+		Namespace:   "default",
+		TaskQueue:   "demo-tq",
+		HTTPPort:    7654,
+		MetricsPort: 9099,
+		CodecHost:   "localhost",
+		CodecPort:   8081,
+
+		CodecCORSOrigins:   []string{"http://localhost:8233", "http://127.0.0.1:8233"},
+		BankGateway:        "stub",
+		BankOpeningBalance: 1000 * money.Unit,
+		LedgerPath:         "ledger.jsonl",
+
+		TracesExporter: "none",
+	}, cfg)
+	require.Equal(t, ":7654", cfg.HTTPAddr())
+	require.Equal(t, ":9099", cfg.MetricsAddr())
+	require.Equal(t, "localhost:8081", cfg.CodecAddr())
+	require.True(t, cfg.CodecLocalOnly())
+	require.False(t, cfg.UseTLS())
+}
+
+func TestLoad_CodecServer(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CODEC_SERVER_HOST", "127.0.0.1")
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	require.Equal(t, "127.0.0.1:8081", cfg.CodecAddr())
+	require.True(t, cfg.CodecLocalOnly())
+
+	// every interface
+	t.Setenv("DEMO_CONFIG", filepath.Join(t.TempDir(), "codec.json"))
+	require.NoError(t, os.WriteFile(os.Getenv("DEMO_CONFIG"), []byte(`{"CodecHost": ""}`), 0o644))
+	t.Setenv("CODEC_SERVER_HOST", "")
+	t.Setenv("CODEC_AUTH_TOKEN", "secret")
+	cfg, err = config.Load()
+	require.NoError(t, err)
+	require.Equal(t, ":8081", cfg.CodecAddr())
+	require.False(t, cfg.CodecLocalOnly())
+	require.Equal(t, "secret", cfg.CodecAuthToken)
+}
+
+func TestLoad_CodecCORSOrigins(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("CODEC_CORS_ORIGINS", "https://cloud.temporal.io, http://localhost:8233")
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	require.Equal(t, []string{"https://cloud.temporal.io", "http://localhost:8233"}, cfg.CodecCORSOrigins)
+
+	// the file's origins don't leak into the defaults of the next load
+	t.Setenv("CODEC_CORS_ORIGINS", "")
+	t.Setenv("DEMO_CONFIG", filepath.Join(t.TempDir(), "cors.json"))
+	require.NoError(t, os.WriteFile(os.Getenv("DEMO_CONFIG"), []byte(`{"CodecCORSOrigins": ["*"]}`), 0o644))
+	cfg, err = config.Load()
+	require.NoError(t, err)
+	require.Equal(t, []string{"*"}, cfg.CodecCORSOrigins)
+	require.Equal(t, []string{"http://localhost:8233", "http://127.0.0.1:8233"}, config.DefaultCodecCORSOrigins)
+
+	t.Setenv("DEMO_CONFIG", "")
+	for _, origin := range []string{"localhost:8233", "https://cloud.temporal.io/namespaces", "ftp://example.com", ""} {
+		t.Setenv("CODEC_CORS_ORIGINS", "http://localhost:8233,"+origin)
+		_, err = config.Load()
+		require.ErrorContains(t, err, "invalid codec CORS origin ("+origin+")", origin)
+	}
+}
+
+func TestLoad_Worker(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("BANK_GATEWAY", "memory")
+	t.Setenv("BANK_OPENING_BALANCE", "250.50")
+	t.Setenv("BANK_RATE_LIMITS", "piggy=1,*=50")
+	t.Setenv("LEDGER_PATH", "/var/lib/demo/ledger.jsonl")
+	t.Setenv("TRANSFER_INACTIVITY_TIMEOUT", "5m")
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	require.Equal(t, "memory", cfg.BankGateway)
+	require.Equal(t, 250*money.Unit+50*money.Cent, cfg.BankOpeningBalance)
+	require.Equal(t, "piggy=1,*=50", cfg.BankRateLimits)
+	require.Equal(t, "/var/lib/demo/ledger.jsonl", cfg.LedgerPath)
+	require.Equal(t, 5*time.Minute, cfg.TransferInactivityTimeout)
+}
+
+func TestLoad_SecuredCluster(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("TEMPORAL_ADDRESS", "payments.a1b2c.tmprl.cloud:7233")
+	t.Setenv("TEMPORAL_NAMESPACE", "payments.a1b2c")
+	t.Setenv("TEMPORAL_TLS_CERT", "/etc/temporal/client.pem")
+	t.Setenv("TEMPORAL_TLS_KEY", "/etc/temporal/client.key")
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	require.Equal(t, "payments.a1b2c.tmprl.cloud:7233", cfg.HostPort)
+	require.Equal(t, "payments.a1b2c", cfg.Namespace)
+	require.Equal(t, "/etc/temporal/client.pem", cfg.TLSCertPath)
+	require.Equal(t, "/etc/temporal/client.key", cfg.TLSKeyPath)
+	require.True(t, cfg.UseTLS())
+
+	// an API key alone implies TLS too
+	clearEnv(t)
+	t.Setenv("TEMPORAL_API_KEY", "secret")
+	cfg, err = config.Load()
+	require.NoError(t, err)
+	require.True(t, cfg.UseTLS())
+}
+
+func TestLoad_EnvOverridesFile(t *testing.T) {
+	clearEnv(t)
+	path := filepath.Join(t.TempDir(), "staging.json")
+	require.NoError(t, os.WriteFile(path,
+		[]byte(`{"Namespace": "staging", "TaskQueue": "staging-tq", "HTTPPort": 8080, "LogLevel": "warn"}`), 0o644))
+	t.Setenv("DEMO_CONFIG", path)
+	t.Setenv("TEMPORAL_TASK_QUEUE", "staging-tq-2")
+	t.Setenv("LOG_LEVEL", "debug")
+
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	require.Equal(t, config.Config{
+		HostPort:    "localhost:7233",
+		Namespace:   "staging",
+		TaskQueue:   "staging-tq-2",
+		HTTPPort:    8080,
+		MetricsPort: 9099,
+		CodecHost:   "localhost",
+		CodecPort:   8081,
+
+		CodecCORSOrigins:   []string{"http://localhost:8233", "http://127.0.0.1:8233"},
+		BankGateway:        "stub",
+		BankOpeningBalance: 1000 * money.Unit,
+		LedgerPath:         "ledger.jsonl",
+
+		LogLevel:       slog.LevelDebug,
+		TracesExporter: "none",
+	}, cfg)
+}
+
+func TestLoad_EncryptionKeys(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("TEMPORAL_ENCRYPTION_KEYS", "2023-09=AQID, 2023-10=BAUG==")
+	t.Setenv("TEMPORAL_ENCRYPTION_KEY_ID", "2023-10")
+	cfg, err := config.Load()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"2023-09": "AQID", "2023-10": "BAUG=="}, cfg.EncryptionKeys)
+	require.Equal(t, "2023-10", cfg.EncryptionKeyID)
+
+	// a single key is the current one
+	t.Setenv("TEMPORAL_ENCRYPTION_KEYS", "2023-09=AQID")
+	t.Setenv("TEMPORAL_ENCRYPTION_KEY_ID", "")
+	cfg, err = config.Load()
+	require.NoError(t, err)
+	require.Equal(t, "2023-09", cfg.EncryptionKeyID)
+
+	t.Setenv("TEMPORAL_ENCRYPTION_KEY_ID", "2023-10")
+	_, err = config.Load()
+	require.ErrorContains(t, err, "encryption key ID (2023-10) is not one of the encryption keys")
+
+	t.Setenv("TEMPORAL_ENCRYPTION_KEYS", "AQID")
+	_, err = config.Load()
+	require.ErrorContains(t, err, "invalid TEMPORAL_ENCRYPTION_KEYS")
+}
+
+func TestLoad_Errors(t *testing.T) {
+	clearEnv(t)
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+		return path
+	}
+
+	t.Setenv("DEMO_CONFIG", filepath.Join(dir, "missing.json"))
+	_, err := config.Load()
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	t.Setenv("DEMO_CONFIG", write("typo.json", `{"TaskQeue": "staging-tq"}`))
+	_, err = config.Load()
+	require.ErrorContains(t, err, `unknown field "TaskQeue"`)
+
+	t.Setenv("DEMO_CONFIG", write("empty-queue.json", `{"TaskQueue": ""}`))
+	_, err = config.Load()
+	require.ErrorContains(t, err, "task queue is not set")
+
+	t.Setenv("DEMO_CONFIG", "")
+	t.Setenv("HTTP_PORT", "http")
+	_, err = config.Load()
+	require.ErrorContains(t, err, "invalid HTTP_PORT")
+
+	t.Setenv("HTTP_PORT", "70000")
+	_, err = config.Load()
+	require.ErrorContains(t, err, "invalid HTTP port (70000)")
+
+	t.Setenv("HTTP_PORT", "")
+	t.Setenv("METRICS_PORT", "0")
+	_, err = config.Load()
+	require.ErrorContains(t, err, "invalid metrics port (0)")
+
+	t.Setenv("METRICS_PORT", "")
+	t.Setenv("LOG_LEVEL", "verbose")
+	_, err = config.Load()
+	require.ErrorContains(t, err, "invalid LOG_LEVEL (verbose)")
+
+	t.Setenv("LOG_LEVEL", "")
+	t.Setenv("OTEL_TRACES_EXPORTER", "jaeger")
+	_, err = config.Load()
+	require.ErrorContains(t, err, "unknown traces exporter (jaeger)")
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "")
+	t.Setenv("TEMPORAL_TLS_CERT", "/etc/temporal/client.pem")
+	_, err = config.Load()
+	require.ErrorContains(t, err, "TLS cert and key must be set together")
+
+	t.Setenv("TEMPORAL_TLS_CERT", "")
+	t.Setenv("BANK_GATEWAY", "postgres")
+	_, err = config.Load()
+	require.ErrorContains(t, err, "unknown bank gateway (postgres)")
+
+	t.Setenv("BANK_GATEWAY", "")
+	t.Setenv("BANK_OPENING_BALANCE", "10.005")
+	_, err = config.Load()
+	require.ErrorContains(t, err, "invalid BANK_OPENING_BALANCE (10.005)")
+
+	t.Setenv("BANK_OPENING_BALANCE", "-10")
+	_, err = config.Load()
+	require.ErrorContains(t, err, "invalid bank opening balance (-10.00)")
+
+	t.Setenv("BANK_OPENING_BALANCE", "")
+	t.Setenv("BANK_RATE_LIMITS", "piggy=fast")
+	_, err = config.Load()
+	require.ErrorContains(t, err, "invalid bank rate limits: invalid rate limit (piggy=fast)")
+
+	t.Setenv("BANK_RATE_LIMITS", "")
+	t.Setenv("DEMO_CONFIG", write("no-ledger.json", `{"LedgerPath": ""}`))
+	_, err = config.Load()
+	require.ErrorContains(t, err, "ledger path is not set")
+
+	t.Setenv("DEMO_CONFIG", "")
+	t.Setenv("TRANSFER_INACTIVITY_TIMEOUT", "5")
+	_, err = config.Load()
+	require.ErrorContains(t, err, "invalid TRANSFER_INACTIVITY_TIMEOUT (5)")
+
+	t.Setenv("TRANSFER_INACTIVITY_TIMEOUT", "-5m")
+	_, err = config.Load()
+	require.ErrorContains(t, err, "invalid transfer inactivity timeout (-5m0s)")
+}
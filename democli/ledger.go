@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"replay-demo/config"
+	"replay-demo/ledger"
+	"replay-demo/logging"
+)
+
+// openLedger opens the journal the worker writes to, at LEDGER_PATH, or exits. The CLI only reads it, so a missing
+// journal is an error rather than created empty.
+func openLedger(logger *slog.Logger, cfg config.Config) *ledger.Ledger {
+	if _, err := os.Stat(cfg.LedgerPath); errors.Is(err, fs.ErrNotExist) {
+		logging.Fatal(logger, "No ledger: set LEDGER_PATH to the worker's", "Path", cfg.LedgerPath)
+	}
+	l, err := ledger.Open(cfg.LedgerPath)
+	if err != nil {
+		logging.Fatal(logger, "Unable to open ledger", "Error", err)
+	}
+	return l
+}
+
+// showBalance logs the balance of an account in a currency, rebuilt from every posting in the ledger.
+func showBalance(ctx context.Context, logger *slog.Logger, l *ledger.Ledger, account, currency string) {
+	logger.InfoContext(ctx, "Balance", "Account", account, "Currency", currency,
+		"Balance", l.Balance(account, currency).String())
+}
+
+// showTransfer logs the entries a workflow run journaled, in the order they were recorded, then how much each holding
+// gained or lost in that run.
+func showTransfer(ctx context.Context, logger *slog.Logger, l *ledger.Ledger, workflowID, runID string) {
+	ctx = logging.WithWorkflow(ctx, workflowID, runID)
+	entries := l.Entries(workflowID, runID)
+	if len(entries) == 0 {
+		logger.WarnContext(ctx, "No ledger entries")
+		return
+	}
+	for _, e := range entries {
+		postings := make([]string, 0, len(e.Postings))
+		for _, p := range e.Postings {
+			postings = append(postings, fmt.Sprintf("%v %v %v", p.Account, p.Amount, p.Currency))
+		}
+		logger.InfoContext(ctx, "Entry", "Kind", e.Kind, "Time", e.Time, "Postings", strings.Join(postings, ", "))
+	}
+
+	net := l.Net(workflowID, runID)
+	holdings := make([]ledger.Holding, 0, len(net))
+	for holding := range net {
+		holdings = append(holdings, holding)
+	}
+	sort.Slice(holdings, func(i, j int) bool {
+		if holdings[i].Account != holdings[j].Account {
+			return holdings[i].Account < holdings[j].Account
+		}
+		return holdings[i].Currency < holdings[j].Currency
+	})
+	for _, holding := range holdings {
+		logger.InfoContext(ctx, "Net", "Account", holding.Account, "Currency", holding.Currency,
+			"Amount", net[holding].String())
+	}
+}
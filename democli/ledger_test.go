@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"replay-demo/ledger"
+	"replay-demo/money"
+)
+
+// newTestLogger logs lines without their time into buf.
+func newTestLogger(buf *bytes.Buffer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
+
+func TestShowBalanceAndTransfer(t *testing.T) {
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	require.NoError(t, err)
+	defer l.Close()
+	require.NoError(t, l.Record(ledger.NewEntry(ledger.KindWithdraw, "transfer-1", "run-1", "from", "USD", 10*money.Unit)))
+	require.NoError(t, l.Record(ledger.NewEntry(ledger.KindDeposit, "transfer-1", "run-1", "to", "USD", 10*money.Unit)))
+	require.NoError(t, l.Record(ledger.NewEntry(ledger.KindWithdraw, "transfer-2", "run-1", "from", "USD", 5*money.Unit)))
+
+	var buf bytes.Buffer
+	logger := newTestLogger(&buf)
+	showBalance(context.Background(), logger, l, "from", "USD")
+	require.Equal(t, "level=INFO msg=Balance Account=from Currency=USD Balance=-15.00\n", buf.String())
+
+	buf.Reset()
+	showTransfer(context.Background(), logger, l, "transfer-1", "run-1")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 5)
+	require.Contains(t, lines[0], `msg=Entry Kind=withdraw`)
+	require.Contains(t, lines[0], `Postings="from -10.00 USD, suspense 10.00 USD"`)
+	require.Contains(t, lines[1], `msg=Entry Kind=deposit`)
+	require.Contains(t, lines[1], `Postings="to 10.00 USD, suspense -10.00 USD"`)
+	// holdings are sorted, and the suspense account nets to zero once the deposit is made
+	require.Equal(t, []string{
+		"level=INFO msg=Net Account=from Currency=USD Amount=-10.00",
+		"level=INFO msg=Net Account=suspense Currency=USD Amount=0.00",
+		"level=INFO msg=Net Account=to Currency=USD Amount=10.00",
+	}, lines[2:])
+
+	buf.Reset()
+	showTransfer(context.Background(), logger, l, "transfer-1", "run-2")
+	require.Equal(t, "level=WARN msg=\"No ledger entries\"\n", buf.String())
+}
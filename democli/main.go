@@ -1,73 +1,244 @@
 package main
 
 import (
+	"bytes"
 	"context"
-	"log"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"time"
 
 	demo "replay-demo/client"
+	"replay-demo/config"
+	"replay-demo/logging"
+	"replay-demo/money"
 	"replay-demo/schedule"
 	"replay-demo/workflows"
 
+	"github.com/gogo/protobuf/jsonpb"
+	"go.temporal.io/api/enums/v1"
+	historypb "go.temporal.io/api/history/v1"
 	"go.temporal.io/sdk/client"
 )
 
 func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		logging.Fatal(slog.Default(), "Invalid config", "Error", err)
+	}
+	logger := logging.New(cfg)
+	// every run of the CLI is one request, which the workflows it starts and the updates it sends log with
+	ctx := logging.WithRequestID(context.Background(), logging.NewRequestID())
 	mode := "schedule"
-	if len(os.Args) == 2 {
+	if len(os.Args) >= 2 {
 		mode = os.Args[1]
 	}
 	switch mode {
 	case "schedule":
-		createSchedules()
+		// the batches read their transfers from the optional file or directory argument
+		var options workflows.BatchTransferOptions
+		if len(os.Args) > 2 {
+			options.Source = os.Args[2]
+		}
+		createSchedules(ctx, logger, cfg, options)
+	case "batch":
+		if len(os.Args) < 3 {
+			logging.Fatal(logger, "Usage: democli batch <file-or-directory>")
+		}
+		startBatch(ctx, logger, cfg, workflows.BatchTransferOptions{Source: os.Args[2]})
+	case "pause", "resume", "abort":
+		if len(os.Args) < 3 {
+			logging.Fatal(logger, "Usage: democli "+mode+" <batch-workflow-id>")
+		}
+		controlBatch(ctx, logger, cfg, os.Args[2], map[string]string{
+			"pause":  workflows.PauseBatchUpdateName,
+			"resume": workflows.ResumeBatchUpdateName,
+			"abort":  workflows.AbortBatchUpdateName,
+		}[mode])
 	case "update":
-		runDemoUpdate()
+		runDemoUpdate(ctx, logger, cfg)
+	case "export-history":
+		if len(os.Args) < 3 {
+			logging.Fatal(logger, "Usage: democli export-history <workflow-id> [run-id]")
+		}
+		var runID string
+		if len(os.Args) > 3 {
+			runID = os.Args[3]
+		}
+		exportHistory(ctx, logger, cfg, os.Args[2], runID)
+	case "ledger":
+		const usage = "Usage: democli ledger balance <account> [currency] | democli ledger transfer <workflow-id> [run-id]"
+		if len(os.Args) < 4 {
+			logging.Fatal(logger, usage)
+		}
+		l := openLedger(logger, cfg)
+		defer l.Close()
+		switch os.Args[2] {
+		case "balance":
+			currency := workflows.BaseCurrency
+			if len(os.Args) > 4 {
+				currency = os.Args[4]
+			}
+			showBalance(ctx, logger, l, os.Args[3], currency)
+		case "transfer":
+			// the entries are kept per run: without one, the latest run of the workflow is shown
+			var runID string
+			if len(os.Args) > 4 {
+				runID = os.Args[4]
+			} else {
+				runID = latestRunID(ctx, logger, cfg, os.Args[3])
+			}
+			showTransfer(ctx, logger, l, os.Args[3], runID)
+		default:
+			logging.Fatal(logger, usage)
+		}
+	}
+}
+
+// latestRunID asks Temporal for the ID of the latest run of a workflow, or exits.
+func latestRunID(ctx context.Context, logger *slog.Logger, cfg config.Config, workflowID string) string {
+	c := newClient(logger, cfg)
+	defer c.Close()
+	ctx = logging.WithWorkflow(ctx, workflowID, "")
+	description, err := c.DescribeWorkflowExecution(ctx, workflowID, "")
+	if err != nil {
+		logging.FatalContext(ctx, logger, "Unable to describe workflow", "Error", err)
+	}
+	return description.GetWorkflowExecutionInfo().GetExecution().GetRunId()
+}
+
+// newClient connects to the Temporal cluster of cfg, or exits.
+func newClient(logger *slog.Logger, cfg config.Config) client.Client {
+	c, err := demo.NewClientWithTelemetry(cfg, demo.Telemetry{Logger: logger})
+	if err != nil {
+		logging.Fatal(logger, "Unable to create client", "Error", err)
 	}
+	return c
 }
 
-func runDemoUpdate() {
-	c := demo.NewClient()
+func runDemoUpdate(ctx context.Context, logger *slog.Logger, cfg config.Config) {
+	c := newClient(logger, cfg)
 	defer c.Close()
-	_, err := c.ExecuteWorkflow(context.Background(), client.StartWorkflowOptions{
+	ctx1 := logging.WithWorkflow(ctx, "transfer-1", "")
+	_, err := c.ExecuteWorkflow(ctx1, client.StartWorkflowOptions{
 		ID:        "transfer-1",
-		TaskQueue: "demo-tq",
-	}, workflows.TransferWorkflow)
+		TaskQueue: cfg.TaskQueue,
+	}, workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
 
 	if err != nil {
-		log.Fatalf("error start wf: %v", err)
+		logging.FatalContext(ctx1, logger, "Unable to start transfer", "Error", err)
 	}
 
-	updateHandle, err := c.UpdateWorkflow(context.Background(), "transfer-1", "", "transfer", workflows.TransferRequest{
+	updateHandle, err := c.UpdateWorkflow(ctx1, "transfer-1", "", "transfer", workflows.TransferRequest{
 		FromAccount: "from-account-id",
 		ToAccount:   "to-account-id-piggy-bank",
-		Amount:      10,
+		Amount:      10 * money.Unit,
 	})
 	if err != nil {
-		log.Fatalf("error update wf: %v", err)
+		logging.FatalContext(ctx1, logger, "Unable to update workflow", "Error", err)
 	}
-	err = updateHandle.Get(context.Background(), nil)
-	log.Printf("Update failed: %v\n", err)
+	err = updateHandle.Get(ctx1, nil)
+	logger.InfoContext(logging.WithUpdateID(ctx1, updateHandle.UpdateID()), "Update failed", "Error", err)
 
-	_, err = c.ExecuteWorkflow(context.Background(), client.StartWorkflowOptions{
+	ctx2 := logging.WithWorkflow(ctx, "transfer-2", "")
+	_, err = c.ExecuteWorkflow(ctx2, client.StartWorkflowOptions{
 		ID:        "transfer-2",
-		TaskQueue: "demo-tq",
-	}, workflows.TransferWorkflow)
+		TaskQueue: cfg.TaskQueue,
+	}, workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
 
 	if err != nil {
-		log.Fatalf("error start wf: %v", err)
+		logging.FatalContext(ctx2, logger, "Unable to start transfer", "Error", err)
 	}
-	_, err = c.UpdateWorkflow(context.Background(), "transfer-2", "", "transfer", workflows.TransferRequest{
+	_, err = c.UpdateWorkflow(ctx2, "transfer-2", "", "transfer", workflows.TransferRequest{
 		FromAccount: "from-account-id",
 		ToAccount:   "to-account-id",
-		Amount:      10,
+		Amount:      10 * money.Unit,
 	})
 }
 
-func createSchedules() {
-	c := demo.NewClient()
+// controlBatch sends a pause, resume or abort update to the latest run of a batch.
+func controlBatch(ctx context.Context, logger *slog.Logger, cfg config.Config, workflowID, updateName string) {
+	c := newClient(logger, cfg)
+	defer c.Close()
+	ctx = logging.WithWorkflow(ctx, workflowID, "")
+	handle, err := c.UpdateWorkflow(ctx, workflowID, "", updateName)
+	if err != nil {
+		logging.FatalContext(ctx, logger, "Unable to update workflow", "Error", err)
+	}
+	ctx = logging.WithUpdateID(ctx, handle.UpdateID())
+	if err := handle.Get(ctx, nil); err != nil {
+		logging.FatalContext(ctx, logger, "Update rejected", "UpdateName", updateName, "Error", err)
+	}
+	logger.InfoContext(ctx, "Update accepted", "UpdateName", updateName)
+}
+
+// historiesDir holds the histories replayed by the workflows package tests.
+const historiesDir = "workflows/testdata/histories"
+
+// exportHistory saves the history of a closed workflow run as JSON into historiesDir, so it can be replayed against
+// future workflow code. An empty runID exports the latest run.
+func exportHistory(ctx context.Context, logger *slog.Logger, cfg config.Config, workflowID, runID string) {
+	c := newClient(logger, cfg)
+	defer c.Close()
+	ctx = logging.WithWorkflow(ctx, workflowID, runID)
+
+	var history historypb.History
+	iter := c.GetWorkflowHistory(ctx, workflowID, runID, false, enums.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT)
+	for iter.HasNext() {
+		event, err := iter.Next()
+		if err != nil {
+			logging.FatalContext(ctx, logger, "Unable to get history", "Error", err)
+		}
+		history.Events = append(history.Events, event)
+	}
+	if len(history.Events) == 0 {
+		logging.FatalContext(ctx, logger, "No history")
+	}
+
+	var buf bytes.Buffer
+	if err := (&jsonpb.Marshaler{Indent: "  "}).Marshal(&buf, &history); err != nil {
+		logging.FatalContext(ctx, logger, "Unable to encode history", "Error", err)
+	}
+	path := filepath.Join(historiesDir, workflowID+".json")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		logging.FatalContext(ctx, logger, "Unable to write history", "Error", err)
+	}
+	logger.InfoContext(ctx, "Exported history", "Events", len(history.Events), "Path", path)
+}
+
+func createSchedules(ctx context.Context, logger *slog.Logger, cfg config.Config, options workflows.BatchTransferOptions) {
+	c := newClient(logger, cfg)
 	defer c.Close()
 	sClient := c.ScheduleClient()
-	schedule.CreateSchedule(sClient, "schedule_every_5s", "payment_every_5s", schedule.MakeSpecEvery5Seconds(), false)
-	schedule.CreateSchedule(sClient, "schedule_business_hourly", "payment_hourly", schedule.MakeSpecBusinessHoursHourly(), true)
-	schedule.CreateSchedule(sClient, "schedule_custom", "payment_custom_schedule", schedule.MakeSpecCustomSchedule(), false)
+	schedule.CreateSchedule(ctx, logger, sClient, "schedule_every_5s", "payment_every_5s", cfg.TaskQueue, schedule.MakeSpecEvery5Seconds(), false, options)
+	schedule.CreateSchedule(ctx, logger, sClient, "schedule_business_hourly", "payment_hourly", cfg.TaskQueue, schedule.MakeSpecBusinessHoursHourly(), true, options)
+	schedule.CreateSchedule(ctx, logger, sClient, "schedule_custom", "payment_custom_schedule", cfg.TaskQueue, schedule.MakeSpecCustomSchedule(), false, options)
+}
+
+// startBatch runs a batch once and waits for its result.
+func startBatch(ctx context.Context, logger *slog.Logger, cfg config.Config, options workflows.BatchTransferOptions) {
+	c := newClient(logger, cfg)
+	defer c.Close()
+	run, err := c.ExecuteWorkflow(ctx, client.StartWorkflowOptions{
+		ID:        "batch-" + time.Now().Format("20060102-150405"),
+		TaskQueue: cfg.TaskQueue,
+	}, workflows.BatchTransferWorkflow, options)
+	if err != nil {
+		logging.FatalContext(ctx, logger, "Unable to start batch", "Error", err)
+	}
+	ctx = logging.WithWorkflow(ctx, run.GetID(), run.GetRunID())
+	logger.InfoContext(ctx, "Started batch", "Source", options.Source)
+
+	var result workflows.BatchTransferResult
+	if err := run.Get(ctx, &result); err != nil {
+		logging.FatalContext(ctx, logger, "Batch failed", "Error", err)
+	}
+	for _, item := range result.Items {
+		if item.Outcome != workflows.BatchTransferOutcomeSucceeded {
+			logger.WarnContext(ctx, "Transfer not done", "Outcome", item.Outcome, "Error", item.Error)
+		}
+	}
+	logger.InfoContext(ctx, "Batch done", "Succeeded", result.Succeeded, "Rejected", result.Rejected,
+		"Compensated", result.Compensated, "Errored", result.Errored, "Duplicate", result.Duplicate,
+		"OmittedFailures", result.OmittedFailures)
 }
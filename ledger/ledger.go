@@ -0,0 +1,197 @@
+package ledger
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"replay-demo/money"
+)
+
+// SuspenseAccount holds the money of a transfer between its withdraw and its deposit. For a transfer across
+// currencies it keeps the exchange position: the source currency in and the destination currency out.
+const SuspenseAccount = "suspense"
+
+type Kind string
+
+const (
+	KindWithdraw       Kind = "withdraw"
+	KindDeposit        Kind = "deposit"
+	KindRevertWithdraw Kind = "revert-withdraw"
+	KindRevertDeposit  Kind = "revert-deposit"
+)
+
+// Posting moves Amount of Currency into Account. A negative amount moves money out of it.
+type Posting struct {
+	Account  string
+	Currency string
+	Amount   money.Amount
+}
+
+// Holding is the money an account holds in one currency.
+type Holding struct {
+	Account  string
+	Currency string
+}
+
+// Entry is a balanced journal entry: in every currency, the amounts of its postings add up to zero.
+type Entry struct {
+	WorkflowID string
+	RunID      string
+	Kind       Kind
+	Postings   []Posting
+	Time       time.Time
+}
+
+// NewEntry builds the journal entry for one step of a transfer, moving amount of currency between accountID and the
+// SuspenseAccount.
+func NewEntry(kind Kind, workflowID, runID, accountID, currency string, amount money.Amount) Entry {
+	// withdraw and revert deposit take money out of the account, deposit and revert withdraw put it back in.
+	if kind == KindWithdraw || kind == KindRevertDeposit {
+		amount = -amount
+	}
+	return Entry{
+		WorkflowID: workflowID,
+		RunID:      runID,
+		Kind:       kind,
+		Postings: []Posting{
+			{Account: accountID, Currency: currency, Amount: amount},
+			{Account: SuspenseAccount, Currency: currency, Amount: -amount},
+		},
+	}
+}
+
+type entryKey struct {
+	workflowID string
+	runID      string
+	kind       Kind
+}
+
+// Ledger is a double-entry journal persisted as an append-only file with one JSON entry per line.
+type Ledger struct {
+	mu      sync.Mutex
+	file    *os.File
+	entries []Entry
+	keys    map[entryKey]struct{}
+}
+
+// Open loads the journal at path, creating it if needed, and appends new entries to it.
+func Open(path string) (*Ledger, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	l := &Ledger{
+		file: file,
+		keys: make(map[entryKey]struct{}),
+	}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("ledger %v line %d: %w", path, line, err)
+		}
+		l.add(e)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Ledger) Close() error {
+	return l.file.Close()
+}
+
+// Record appends e to the journal. An entry is recorded at most once per workflow run and kind, so recording it
+// again is a no-op.
+func (l *Ledger) Record(e Entry) error {
+	if e.WorkflowID == "" || e.RunID == "" {
+		return errors.New("ledger entry must have a workflow ID and run ID")
+	}
+	if len(e.Postings) < 2 {
+		return fmt.Errorf("ledger entry %v for %v/%v needs at least two postings", e.Kind, e.WorkflowID, e.RunID)
+	}
+	sums := make(map[string]money.Amount)
+	for _, p := range e.Postings {
+		sums[p.Currency] += p.Amount
+	}
+	for currency, sum := range sums {
+		if sum != 0 {
+			return fmt.Errorf("ledger entry %v for %v/%v is not balanced in %v", e.Kind, e.WorkflowID, e.RunID, currency)
+		}
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.keys[keyOf(e)]; ok {
+		return nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	l.add(e)
+	return nil
+}
+
+// Balance rebuilds the balance of an account in currency from every posting in the journal.
+func (l *Ledger) Balance(account, currency string) money.Amount {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var balance money.Amount
+	for _, e := range l.entries {
+		for _, p := range e.Postings {
+			if p.Account == account && p.Currency == currency {
+				balance += p.Amount
+			}
+		}
+	}
+	return balance
+}
+
+// Entries returns the entries recorded by a workflow run, in the order they were recorded.
+func (l *Ledger) Entries(workflowID, runID string) []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var entries []Entry
+	for _, e := range l.entries {
+		if e.WorkflowID == workflowID && e.RunID == runID {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Net returns how much each account gained or lost per currency in a workflow run. A transfer that was fully
+// compensated nets to zero on every holding.
+func (l *Ledger) Net(workflowID, runID string) map[Holding]money.Amount {
+	net := make(map[Holding]money.Amount)
+	for _, e := range l.Entries(workflowID, runID) {
+		for _, p := range e.Postings {
+			net[Holding{Account: p.Account, Currency: p.Currency}] += p.Amount
+		}
+	}
+	return net
+}
+
+func (l *Ledger) add(e Entry) {
+	l.entries = append(l.entries, e)
+	l.keys[keyOf(e)] = struct{}{}
+}
+
+func keyOf(e Entry) entryKey {
+	return entryKey{workflowID: e.WorkflowID, runID: e.RunID, kind: e.Kind}
+}
@@ -0,0 +1,63 @@
+package ledger_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"replay-demo/ledger"
+	"replay-demo/money"
+)
+
+func TestLedger_CompensatedTransferNetsToZero(t *testing.T) {
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	require.NoError(t, err)
+	defer l.Close()
+
+	require.NoError(t, l.Record(ledger.NewEntry(ledger.KindWithdraw, "transfer-1", "run-1", "from", "USD", 10*money.Unit)))
+	require.NoError(t, l.Record(ledger.NewEntry(ledger.KindDeposit, "transfer-1", "run-1", "to", "USD", 10*money.Unit)))
+	require.NoError(t, l.Record(ledger.NewEntry(ledger.KindRevertDeposit, "transfer-1", "run-1", "to", "USD", 10*money.Unit)))
+	require.NoError(t, l.Record(ledger.NewEntry(ledger.KindRevertWithdraw, "transfer-1", "run-1", "from", "USD", 10*money.Unit)))
+
+	require.Len(t, l.Entries("transfer-1", "run-1"), 4)
+	for holding, net := range l.Net("transfer-1", "run-1") {
+		require.Zero(t, net, holding)
+	}
+}
+
+func TestLedger_RebuildBalanceAfterReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	l, err := ledger.Open(path)
+	require.NoError(t, err)
+	require.NoError(t, l.Record(ledger.NewEntry(ledger.KindWithdraw, "transfer-1", "run-1", "from", "USD", 10*money.Unit)))
+	require.NoError(t, l.Record(ledger.NewEntry(ledger.KindDeposit, "transfer-1", "run-1", "to", "USD", 10*money.Unit)))
+	require.NoError(t, l.Record(ledger.NewEntry(ledger.KindWithdraw, "transfer-2", "run-1", "from", "USD", 5*money.Unit)))
+	// recording the same step again is a no-op
+	require.NoError(t, l.Record(ledger.NewEntry(ledger.KindWithdraw, "transfer-2", "run-1", "from", "USD", 5*money.Unit)))
+	require.NoError(t, l.Close())
+
+	l, err = ledger.Open(path)
+	require.NoError(t, err)
+	defer l.Close()
+	require.Equal(t, -15*money.Unit, l.Balance("from", "USD"))
+	require.Equal(t, 10*money.Unit, l.Balance("to", "USD"))
+	require.Equal(t, 5*money.Unit, l.Balance(ledger.SuspenseAccount, "USD"))
+}
+
+func TestLedger_RejectUnbalancedEntry(t *testing.T) {
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	require.NoError(t, err)
+	defer l.Close()
+
+	err = l.Record(ledger.Entry{
+		WorkflowID: "transfer-1",
+		RunID:      "run-1",
+		Kind:       ledger.KindWithdraw,
+		Postings: []ledger.Posting{
+			{Account: "from", Currency: "USD", Amount: -10 * money.Unit},
+			{Account: ledger.SuspenseAccount, Currency: "EUR", Amount: 10 * money.Unit},
+		},
+	})
+	require.ErrorContains(t, err, "not balanced")
+	require.Zero(t, l.Balance("from", "USD"))
+}
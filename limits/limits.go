@@ -0,0 +1,50 @@
+package limits
+
+import (
+	"errors"
+	"fmt"
+
+	"replay-demo/money"
+)
+
+var ErrLimitExceeded = errors.New("daily limit exceeded")
+
+// Daily is the limit of one account on one day, with the reservations made out of it. A transfer reserves headroom
+// before it moves money and releases it if the transfer is compensated. It is the state of the workflow enforcing the
+// limit, which every worker reserves through, so it is a plain value and not safe for concurrent use.
+type Daily struct {
+	AccountID string
+	Day       string
+	Limit     money.Amount
+	// Reservations are the amounts reserved, by reservation ID.
+	Reservations map[string]money.Amount
+}
+
+// Reserve takes amount out of the headroom. Reserving the same reservationID again is a no-op.
+func (d *Daily) Reserve(reservationID string, amount money.Amount) error {
+	if _, ok := d.Reservations[reservationID]; ok {
+		return nil
+	}
+	if used := d.Used(); used+amount > d.Limit {
+		return fmt.Errorf("%w: account %v already used %v of %v on %v", ErrLimitExceeded, d.AccountID, used, d.Limit, d.Day)
+	}
+	if d.Reservations == nil {
+		d.Reservations = make(map[string]money.Amount)
+	}
+	d.Reservations[reservationID] = amount
+	return nil
+}
+
+// Release gives the headroom of a reservation back. Releasing an unknown or already released reservation is a no-op.
+func (d *Daily) Release(reservationID string) {
+	delete(d.Reservations, reservationID)
+}
+
+// Used returns how much of the limit is reserved.
+func (d *Daily) Used() money.Amount {
+	var used money.Amount
+	for _, amount := range d.Reservations {
+		used += amount
+	}
+	return used
+}
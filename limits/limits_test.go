@@ -0,0 +1,35 @@
+package limits_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"replay-demo/limits"
+	"replay-demo/money"
+)
+
+func TestDaily_ReserveAndReleaseAreIdempotent(t *testing.T) {
+	daily := limits.Daily{AccountID: "account", Day: "2023-09-12", Limit: 100 * money.Unit}
+
+	require.NoError(t, daily.Reserve("transfer-1", 60*money.Unit))
+	require.NoError(t, daily.Reserve("transfer-1", 60*money.Unit))
+	require.Equal(t, 60*money.Unit, daily.Used())
+	err := daily.Reserve("transfer-2", 60*money.Unit)
+	require.ErrorIs(t, err, limits.ErrLimitExceeded)
+	require.ErrorContains(t, err, "account account already used 60.00 of 100.00 on 2023-09-12")
+
+	daily.Release("transfer-1")
+	daily.Release("transfer-1")
+	require.Zero(t, daily.Used())
+	require.NoError(t, daily.Reserve("transfer-2", 60*money.Unit))
+}
+
+func TestDaily_UpToTheLimit(t *testing.T) {
+	daily := limits.Daily{AccountID: "account", Day: "2023-09-12", Limit: 500 * money.Unit}
+
+	for i := 0; i < 50; i++ {
+		require.NoError(t, daily.Reserve(string(rune('a'+i)), 10*money.Unit))
+	}
+	require.Equal(t, 500*money.Unit, daily.Used())
+	require.ErrorIs(t, daily.Reserve("one-cent-more", money.Cent), limits.ErrLimitExceeded)
+}
@@ -0,0 +1,134 @@
+// Package logging is the structured logger of the worker, the HTTP server and the CLI. Every line carries the workflow
+// ID, run ID, update ID and request ID it concerns, so one request can be followed from the HTTP server into the
+// workflows and activities it drives.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+
+	"github.com/google/uuid"
+	"replay-demo/config"
+)
+
+// The keys of the correlation fields, as the Temporal SDK names them in its own lines.
+const (
+	WorkflowIDKey = "WorkflowID"
+	RunIDKey      = "RunID"
+	UpdateIDKey   = "UpdateID"
+	RequestIDKey  = "RequestID"
+)
+
+// RequestIDHeader is the HTTP header a request ID is read from, and sent back in.
+const RequestIDHeader = "X-Request-ID"
+
+// New logs to stderr at the level of cfg, adding the correlation fields of the context of each line.
+func New(cfg config.Config) *slog.Logger {
+	return slog.New(NewHandler(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: cfg.LogLevel})))
+}
+
+// Fatal logs msg at error level and exits, for the errors a command can't go on from.
+func Fatal(logger *slog.Logger, msg string, args ...any) {
+	FatalContext(context.Background(), logger, msg, args...)
+}
+
+// FatalContext is Fatal with the correlation fields of ctx.
+func FatalContext(ctx context.Context, logger *slog.Logger, msg string, args ...any) {
+	logger.ErrorContext(ctx, msg, args...)
+	os.Exit(1)
+}
+
+// fields are the correlation fields a context carries.
+type fields struct {
+	workflowID string
+	runID      string
+	updateID   string
+	requestID  string
+}
+
+type fieldsKey struct{}
+
+func fieldsFromContext(ctx context.Context) fields {
+	f, _ := ctx.Value(fieldsKey{}).(fields)
+	return f
+}
+
+// WithWorkflow is ctx with the lines logged with it concerning the workflow run. An empty runID is the latest run.
+func WithWorkflow(ctx context.Context, workflowID, runID string) context.Context {
+	f := fieldsFromContext(ctx)
+	f.workflowID, f.runID = workflowID, runID
+	return context.WithValue(ctx, fieldsKey{}, f)
+}
+
+// WithUpdateID is ctx with the lines logged with it concerning the update.
+func WithUpdateID(ctx context.Context, updateID string) context.Context {
+	f := fieldsFromContext(ctx)
+	f.updateID = updateID
+	return context.WithValue(ctx, fieldsKey{}, f)
+}
+
+// WithRequestID is ctx with the lines logged with it concerning the request. The request ID is sent along with the
+// workflows started and the updates sent with ctx, so their lines carry it too.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	f := fieldsFromContext(ctx)
+	f.requestID = requestID
+	return context.WithValue(ctx, fieldsKey{}, f)
+}
+
+// RequestID is the request ID of ctx, if any.
+func RequestID(ctx context.Context) string {
+	return fieldsFromContext(ctx).requestID
+}
+
+// NewRequestID is a request ID for a request that came without one.
+func NewRequestID() string {
+	return uuid.NewString()
+}
+
+// InstrumentHTTP gives each request handler serves a request ID: the one of its RequestIDHeader, or a new one. The
+// request's context carries it, and the response sends it back.
+func InstrumentHTTP(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" {
+			requestID = NewRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+		handler.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), requestID)))
+	})
+}
+
+// handler adds the correlation fields of the context of each record.
+type handler struct {
+	slog.Handler
+}
+
+// NewHandler logs to h, adding the correlation fields of the context of each record.
+func NewHandler(h slog.Handler) slog.Handler {
+	return handler{Handler: h}
+}
+
+func (h handler) Handle(ctx context.Context, r slog.Record) error {
+	f := fieldsFromContext(ctx)
+	for _, attr := range []slog.Attr{
+		slog.String(WorkflowIDKey, f.workflowID),
+		slog.String(RunIDKey, f.runID),
+		slog.String(UpdateIDKey, f.updateID),
+		slog.String(RequestIDKey, f.requestID),
+	} {
+		if attr.Value.String() != "" {
+			r.AddAttrs(attr)
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return handler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h handler) WithGroup(name string) slog.Handler {
+	return handler{Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,73 @@
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"replay-demo/logging"
+)
+
+// newLogger logs every level as JSON into the returned buffer.
+func newLogger() (*slog.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return slog.New(logging.NewHandler(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))), &buf
+}
+
+// lines are the lines logged into buf.
+func lines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var lines []map[string]interface{}
+	decoder := json.NewDecoder(buf)
+	for decoder.More() {
+		var line map[string]interface{}
+		require.NoError(t, decoder.Decode(&line))
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestHandler_CorrelationFields(t *testing.T) {
+	logger, buf := newLogger()
+	ctx := logging.WithRequestID(context.Background(), "request-1")
+	ctx = logging.WithWorkflow(ctx, "transfer-1", "run-1")
+	logger.InfoContext(logging.WithUpdateID(ctx, "update-1"), "Update accepted", "UpdateName", "transfer")
+	logger.InfoContext(ctx, "Started transfer")
+	logger.Info("Starting server")
+
+	logged := lines(t, buf)
+	require.Len(t, logged, 3)
+	require.Equal(t, "transfer-1", logged[0]["WorkflowID"])
+	require.Equal(t, "run-1", logged[0]["RunID"])
+	require.Equal(t, "update-1", logged[0]["UpdateID"])
+	require.Equal(t, "request-1", logged[0]["RequestID"])
+	require.Equal(t, "transfer", logged[0]["UpdateName"])
+	// fields a context doesn't have are left out
+	require.NotContains(t, logged[1], "UpdateID")
+	require.Equal(t, "request-1", logged[1]["RequestID"])
+	require.NotContains(t, logged[2], "RequestID")
+}
+
+func TestInstrumentHTTP(t *testing.T) {
+	var requestIDs []string
+	handler := logging.InstrumentHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestIDs = append(requestIDs, logging.RequestID(r.Context()))
+	}))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/amount", nil)
+	req.Header.Set(logging.RequestIDHeader, "request-1")
+	handler.ServeHTTP(rec, req)
+	require.Equal(t, "request-1", rec.Header().Get(logging.RequestIDHeader))
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/amount", nil))
+	require.Len(t, requestIDs, 2)
+	require.Equal(t, "request-1", requestIDs[0])
+	require.NotEmpty(t, requestIDs[1])
+	require.Equal(t, requestIDs[1], rec.Header().Get(logging.RequestIDHeader))
+}
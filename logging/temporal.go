@@ -0,0 +1,202 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/log"
+	"go.temporal.io/sdk/workflow"
+)
+
+// temporalLogger writes the lines of the SDK, and those of the workflows and activities, with a slog logger. The SDK
+// adds the workflow ID and run ID itself.
+type temporalLogger struct {
+	logger *slog.Logger
+}
+
+// NewTemporalLogger is the logger of a client, and the workers it runs, writing to logger.
+func NewTemporalLogger(logger *slog.Logger) log.Logger {
+	return temporalLogger{logger: logger}
+}
+
+func (l temporalLogger) Debug(msg string, keyvals ...interface{}) { l.logger.Debug(msg, keyvals...) }
+func (l temporalLogger) Info(msg string, keyvals ...interface{})  { l.logger.Info(msg, keyvals...) }
+func (l temporalLogger) Warn(msg string, keyvals ...interface{})  { l.logger.Warn(msg, keyvals...) }
+func (l temporalLogger) Error(msg string, keyvals ...interface{}) { l.logger.Error(msg, keyvals...) }
+
+func (l temporalLogger) With(keyvals ...interface{}) log.Logger {
+	return temporalLogger{logger: l.logger.With(keyvals...)}
+}
+
+// The headers the correlation fields travel in.
+const (
+	requestIDHeader = "replay-demo-request-id"
+	updateIDHeader  = "replay-demo-update-id"
+)
+
+// propagator sends the request ID with the workflows started and the updates sent, on to the activities they run.
+// Activities get the update ID of the handler scheduling them too.
+type propagator struct{}
+
+// NewContextPropagator carries the correlation fields across Temporal, see WithRequestID.
+func NewContextPropagator() workflow.ContextPropagator {
+	return propagator{}
+}
+
+func (propagator) Inject(ctx context.Context, writer workflow.HeaderWriter) error {
+	// the update ID of a client's context is its own, not that of the updates it sends
+	return writeHeader(writer, requestIDHeader, RequestID(ctx))
+}
+
+func (propagator) InjectFromWorkflow(ctx workflow.Context, writer workflow.HeaderWriter) error {
+	f := workflowFields(ctx)
+	if err := writeHeader(writer, requestIDHeader, f.requestID); err != nil {
+		return err
+	}
+	return writeHeader(writer, updateIDHeader, f.updateID)
+}
+
+func (propagator) Extract(ctx context.Context, reader workflow.HeaderReader) (context.Context, error) {
+	// only activities are given a context with headers
+	requestID, err := readHeader(reader, requestIDHeader)
+	if err != nil {
+		return nil, err
+	}
+	updateID, err := readHeader(reader, updateIDHeader)
+	if err != nil {
+		return nil, err
+	}
+	return WithUpdateID(WithRequestID(ctx, requestID), updateID), nil
+}
+
+func (propagator) ExtractToWorkflow(ctx workflow.Context, reader workflow.HeaderReader) (workflow.Context, error) {
+	// an update without a request ID doesn't take the one the workflow was started with
+	requestID, err := readHeader(reader, requestIDHeader)
+	if err != nil {
+		return nil, err
+	}
+	return workflow.WithValue(ctx, fieldsKey{}, fields{requestID: requestID}), nil
+}
+
+func writeHeader(writer workflow.HeaderWriter, key, value string) error {
+	if value == "" {
+		return nil
+	}
+	payload, err := converter.GetDefaultDataConverter().ToPayload(value)
+	if err != nil {
+		return err
+	}
+	writer.Set(key, payload)
+	return nil
+}
+
+func readHeader(reader workflow.HeaderReader, key string) (string, error) {
+	var value string
+	if payload, ok := reader.Get(key); ok {
+		if err := converter.GetDefaultDataConverter().FromPayload(payload, &value); err != nil {
+			return "", err
+		}
+	}
+	return value, nil
+}
+
+// loggingInterceptor adds the update ID and request ID to the loggers of workflows and activities.
+type loggingInterceptor struct {
+	interceptor.InterceptorBase
+}
+
+// NewInterceptor adds the update ID and request ID to the loggers of the workflows and activities a worker runs. It
+// needs the propagator of NewContextPropagator.
+func NewInterceptor() interceptor.Interceptor {
+	return &loggingInterceptor{}
+}
+
+func (*loggingInterceptor) InterceptWorkflow(
+	ctx workflow.Context,
+	next interceptor.WorkflowInboundInterceptor,
+) interceptor.WorkflowInboundInterceptor {
+	in := &workflowInboundInterceptor{}
+	in.Next = next
+	return in
+}
+
+func (*loggingInterceptor) InterceptActivity(
+	ctx context.Context,
+	next interceptor.ActivityInboundInterceptor,
+) interceptor.ActivityInboundInterceptor {
+	in := &activityInboundInterceptor{}
+	in.Next = next
+	return in
+}
+
+type workflowInboundInterceptor struct {
+	interceptor.WorkflowInboundInterceptorBase
+}
+
+func (in *workflowInboundInterceptor) ValidateUpdate(ctx workflow.Context, input *interceptor.UpdateInput) error {
+	return in.Next.ValidateUpdate(withUpdateID(ctx), input)
+}
+
+func (in *workflowInboundInterceptor) ExecuteUpdate(
+	ctx workflow.Context,
+	input *interceptor.UpdateInput,
+) (interface{}, error) {
+	return in.Next.ExecuteUpdate(withUpdateID(ctx), input)
+}
+
+func (in *workflowInboundInterceptor) Init(outbound interceptor.WorkflowOutboundInterceptor) error {
+	out := &workflowOutboundInterceptor{}
+	out.Next = outbound
+	return in.Next.Init(out)
+}
+
+type workflowOutboundInterceptor struct {
+	interceptor.WorkflowOutboundInterceptorBase
+}
+
+func (out *workflowOutboundInterceptor) GetLogger(ctx workflow.Context) log.Logger {
+	return withFields(out.Next.GetLogger(ctx), workflowFields(ctx))
+}
+
+type activityInboundInterceptor struct {
+	interceptor.ActivityInboundInterceptorBase
+}
+
+func (in *activityInboundInterceptor) Init(outbound interceptor.ActivityOutboundInterceptor) error {
+	out := &activityOutboundInterceptor{}
+	out.Next = outbound
+	return in.Next.Init(out)
+}
+
+type activityOutboundInterceptor struct {
+	interceptor.ActivityOutboundInterceptorBase
+}
+
+func (out *activityOutboundInterceptor) GetLogger(ctx context.Context) log.Logger {
+	return withFields(out.Next.GetLogger(ctx), fieldsFromContext(ctx))
+}
+
+// withFields is logger adding the update ID and request ID of f. The SDK adds the workflow ID and run ID already.
+func withFields(logger log.Logger, f fields) log.Logger {
+	if f.updateID != "" {
+		logger = log.With(logger, UpdateIDKey, f.updateID)
+	}
+	if f.requestID != "" {
+		logger = log.With(logger, RequestIDKey, f.requestID)
+	}
+	return logger
+}
+
+func workflowFields(ctx workflow.Context) fields {
+	f, _ := ctx.Value(fieldsKey{}).(fields)
+	return f
+}
+
+// withUpdateID is the context of an update handler with its update ID, which the SDK only tells within the handler.
+func withUpdateID(ctx workflow.Context) workflow.Context {
+	f := workflowFields(ctx)
+	f.updateID = workflow.GetUpdateInfo(ctx).ID
+	return workflow.WithValue(ctx, fieldsKey{}, f)
+}
@@ -0,0 +1,65 @@
+package logging_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+	"replay-demo/logging"
+	"replay-demo/money"
+	"replay-demo/workflows"
+)
+
+type headerWriter map[string]*commonpb.Payload
+
+func (h headerWriter) Set(key string, value *commonpb.Payload) { h[key] = value }
+
+type updateCallback struct{}
+
+func (*updateCallback) Accept()                     {}
+func (*updateCallback) Reject(error)                {}
+func (*updateCallback) Complete(interface{}, error) {}
+
+func TestInterceptor_WorkflowLines(t *testing.T) {
+	logger, buf := newLogger()
+	propagator := logging.NewContextPropagator()
+	var suite testsuite.WorkflowTestSuite
+	suite.SetLogger(logging.NewTemporalLogger(logger))
+	suite.SetContextPropagators([]workflow.ContextPropagator{propagator})
+	// the workflow is started by a request
+	header := headerWriter{}
+	require.NoError(t, propagator.Inject(logging.WithRequestID(context.Background(), "request-1"), header))
+	suite.SetHeader(&commonpb.Header{Fields: header})
+
+	env := suite.NewTestWorkflowEnvironment()
+	env.SetWorkerOptions(worker.Options{Interceptors: []interceptor.WorkerInterceptor{logging.NewInterceptor()}})
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.TransferUpdateName, "update-1", &updateCallback{}, workflows.TransferRequest{
+			FromAccount: "my-from-account",
+			ToAccount:   "my-to-account",
+			Amount:      -1 * money.Unit,
+		})
+	}, time.Second)
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{InactivityTimeout: time.Minute})
+	require.NoError(t, env.GetWorkflowError())
+
+	messages := make(map[string]map[string]interface{})
+	for _, line := range lines(t, buf) {
+		messages[line["msg"].(string)] = line
+	}
+	// a worker's SDK adds the workflow ID and run ID too, but the test env doesn't
+	rejected := messages["Rejecting transfer request"]
+	require.NotNil(t, rejected)
+	require.Equal(t, "update-1", rejected["UpdateID"])
+	abandoned := messages["Abandoning inactive transfer"]
+	require.NotNil(t, abandoned)
+	require.Equal(t, "request-1", abandoned["RequestID"])
+	require.NotContains(t, abandoned, "UpdateID")
+}
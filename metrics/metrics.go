@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/uber-go/tally/v4"
+	"github.com/uber-go/tally/v4/prometheus"
+	"go.temporal.io/sdk/client"
+	sdktally "go.temporal.io/sdk/contrib/tally"
+)
+
+const (
+	// HTTPRequestsMetricName counts the requests of the HTTP server, by method, route and status code.
+	HTTPRequestsMetricName = "http_requests"
+	// HTTPRequestLatencyMetricName is how long the HTTP server took to answer, by method, route and status code.
+	HTTPRequestLatencyMetricName = "http_request_latency"
+
+	// reportInterval is how often metrics are handed to Prometheus.
+	reportInterval = time.Second
+)
+
+// sanitizeOptions make metric names and tag keys valid Prometheus names. Tag values are kept as they are, so that
+// task queues, update names and routes read as they do elsewhere.
+var sanitizeOptions = tally.SanitizeOptions{
+	NameCharacters:       sdktally.PrometheusSanitizeOptions.NameCharacters,
+	KeyCharacters:        sdktally.PrometheusSanitizeOptions.KeyCharacters,
+	ValueCharacters:      tally.ValidCharacters{Ranges: []tally.SanitizeRange{{0, unicode.MaxRune}}},
+	ReplacementCharacter: tally.DefaultReplacementCharacter,
+}
+
+// timerBuckets, in seconds, cover both requests to Temporal and batches running for an hour.
+var timerBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800, 3600}
+
+// Prometheus collects the metrics of the process, those of the Temporal SDK included, for Prometheus to scrape from
+// its HTTP handler.
+type Prometheus struct {
+	scope   tally.Scope
+	closer  io.Closer
+	handler http.Handler
+}
+
+// NewPrometheus starts collecting metrics, in a registry of its own along with the Go runtime and process metrics.
+func NewPrometheus() *Prometheus {
+	registry := prom.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	reporter := prometheus.NewReporter(prometheus.Options{
+		Registerer:              registry,
+		Gatherer:                registry,
+		DefaultTimerType:        prometheus.HistogramTimerType,
+		DefaultHistogramBuckets: timerBuckets,
+	})
+	scope, closer := tally.NewRootScope(tally.ScopeOptions{
+		CachedReporter:  reporter,
+		Separator:       prometheus.DefaultSeparator,
+		SanitizeOptions: &sanitizeOptions,
+	}, reportInterval)
+	return &Prometheus{
+		// counters end in _total and timers in _seconds, as Prometheus names them
+		scope:   sdktally.NewPrometheusNamingScope(scope),
+		closer:  closer,
+		handler: reporter.HTTPHandler(),
+	}
+}
+
+// MetricsHandler records metrics for Prometheus. Set it as the MetricsHandler of the Temporal client for the SDK's
+// metrics, and those workflows and activities record, to be collected.
+func (p *Prometheus) MetricsHandler() client.MetricsHandler {
+	return sdktally.NewMetricsHandler(p.scope)
+}
+
+// ServeHTTP serves the metrics in the Prometheus text format, usually as /metrics.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.handler.ServeHTTP(w, r)
+}
+
+// Close reports the last metrics and stops reporting.
+func (p *Prometheus) Close() error {
+	return p.closer.Close()
+}
+
+// InstrumentHTTP records the HTTPRequestsMetricName and HTTPRequestLatencyMetricName metrics of the requests mux
+// serves. Requests are told apart by the pattern of their route rather than their path, so unknown paths don't make up
+// new series.
+func InstrumentHTTP(metricsHandler client.MetricsHandler, mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		mux.ServeHTTP(rec, r)
+
+		route := "unmatched"
+		if _, pattern := mux.Handler(r); pattern != "" {
+			route = pattern
+		}
+		handler := metricsHandler.WithTags(map[string]string{
+			"method": r.Method,
+			"route":  route,
+			"status": strconv.Itoa(rec.status),
+		})
+		handler.Counter(HTTPRequestsMetricName).Inc(1)
+		handler.Timer(HTTPRequestLatencyMetricName).Record(time.Since(start))
+	})
+}
+
+// statusRecorder remembers the status code of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,59 @@
+package metrics_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"replay-demo/metrics"
+)
+
+// scrape reads the metrics p serves, once they were all reported.
+func scrape(t *testing.T, p *metrics.Prometheus) string {
+	require.NoError(t, p.Close())
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestPrometheus_PrometheusNames(t *testing.T) {
+	p := metrics.NewPrometheus()
+	handler := p.MetricsHandler().WithTags(map[string]string{"task_queue": "demo-tq"})
+	handler.Counter("transfers_started").Inc(2)
+	handler.Timer("batch_transfer_duration").Record(90 * time.Second)
+
+	body := scrape(t, p)
+	require.Contains(t, body, `transfers_started_total{task_queue="demo-tq"} 2`)
+	require.Contains(t, body, `batch_transfer_duration_seconds_bucket{task_queue="demo-tq",le="120"} 1`)
+	require.Contains(t, body, `batch_transfer_duration_seconds_bucket{task_queue="demo-tq",le="60"} 0`)
+	require.Contains(t, body, "go_goroutines")
+}
+
+func TestInstrumentHTTP(t *testing.T) {
+	p := metrics.NewPrometheus()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/batch/status", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "workflowID is required", http.StatusBadRequest)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	})
+	handler := metrics.InstrumentHTTP(p.MetricsHandler(), mux)
+
+	for _, target := range []string{"/status?workflowID=transfer-1", "/status?workflowID=transfer-2", "/batch/status", "/wp-login.php", "/.env"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	body := scrape(t, p)
+	require.Contains(t, body, `http_requests_total{method="GET",route="/status",status="200"} 2`)
+	require.Contains(t, body, `http_requests_total{method="GET",route="/batch/status",status="400"} 1`)
+	// unknown paths share one series
+	require.Contains(t, body, `http_requests_total{method="GET",route="unmatched",status="404"} 2`)
+	require.Contains(t, body, `http_request_latency_seconds_count{method="GET",route="/status",status="200"} 2`)
+}
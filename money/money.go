@@ -0,0 +1,134 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Amount is an amount of money in hundredths of a currency unit (cents), so it is exact to the cent.
+//
+// It is encoded in JSON as a number with two decimals, e.g. 12.50. Decoding also accepts decimal strings, and numbers
+// with more decimals so payloads recorded when amounts were float64 still decode, rounded to the cent. New input is
+// read with ParseJSON, which rejects them like Parse.
+type Amount int64
+
+const (
+	Cent Amount = 1
+	// Unit is one whole currency unit, e.g. one dollar.
+	Unit Amount = 100
+)
+
+// FromFloat converts f currency units into an Amount, rounding half away from zero to the cent.
+func FromFloat(f float64) Amount {
+	return Amount(math.Round(f * 100))
+}
+
+// Parse parses a decimal string such as "12.5", "-3" or "1000.25". More than two decimals is an error.
+func Parse(s string) (Amount, error) {
+	s = strings.TrimSpace(s)
+	digits := strings.TrimPrefix(strings.TrimPrefix(s, "-"), "+")
+	units, cents, hasCents := strings.Cut(digits, ".")
+	if units == "" || !isDigits(units) || (hasCents && (cents == "" || len(cents) > 2 || !isDigits(cents))) {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	u, err := strconv.ParseInt(units, 10, 64)
+	if err != nil || u > math.MaxInt64/100-1 {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	a := Amount(u) * Unit
+	if hasCents {
+		c, _ := strconv.ParseInt((cents + "0")[:2], 10, 64)
+		a += Amount(c)
+	}
+	if strings.HasPrefix(s, "-") {
+		a = -a
+	}
+	return a, nil
+}
+
+// ParseJSON parses an amount encoded in JSON as a number or a decimal string. Like Parse, more than two decimals is an
+// error. A missing amount or null is zero.
+func ParseJSON(data []byte) (Amount, error) {
+	s := string(data)
+	if len(data) == 0 || s == "null" {
+		return 0, nil
+	}
+	if data[0] == '"' {
+		if err := json.Unmarshal(data, &s); err != nil {
+			return 0, err
+		}
+	}
+	return Parse(s)
+}
+
+func (a Amount) Cents() int64 {
+	return int64(a)
+}
+
+// Float64 returns the amount in currency units, for display and formatting only.
+func (a Amount) Float64() float64 {
+	return float64(a) / 100
+}
+
+// Mul multiplies the amount by a rate, rounding half away from zero to the cent.
+func (a Amount) Mul(rate float64) Amount {
+	return Amount(math.Round(float64(a) * rate))
+}
+
+// Round rounds the amount half away from zero to the given number of decimals, from 0 for whole units to 2 for cents.
+func (a Amount) Round(decimals int) Amount {
+	step := Amount(1)
+	for d := decimals; d < 2; d++ {
+		step *= 10
+	}
+	rest := a % step
+	a -= rest
+	if 2*rest >= step {
+		a += step
+	} else if 2*rest <= -step {
+		a -= step
+	}
+	return a
+}
+
+// String formats the amount with two decimals, e.g. "-12.50".
+func (a Amount) String() string {
+	sign := ""
+	abs := int64(a)
+	if abs < 0 {
+		sign = "-"
+		abs = -abs
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, abs/100, abs%100)
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if parsed, err := ParseJSON(data); err == nil {
+		*a = parsed
+		return nil
+	}
+	// Compatibility with amounts encoded as float64, which may have any number of decimals.
+	f, err := strconv.ParseFloat(string(data), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return errors.New("money: invalid amount " + string(data))
+	}
+	*a = FromFloat(f)
+	return nil
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
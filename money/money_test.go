@@ -0,0 +1,91 @@
+package money_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"replay-demo/money"
+)
+
+func TestParse(t *testing.T) {
+	for s, expected := range map[string]money.Amount{
+		"0":         0,
+		"12":        12 * money.Unit,
+		"12.5":      12*money.Unit + 50,
+		"12.05":     12*money.Unit + 5,
+		"-3.25":     -(3*money.Unit + 25),
+		"100000.00": 100000 * money.Unit,
+	} {
+		a, err := money.Parse(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expected, a, s)
+	}
+
+	for _, s := range []string{"", ".5", "1.", "1.234", "1,000", "abc", "1e3"} {
+		_, err := money.Parse(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestAmount_JSON(t *testing.T) {
+	data, err := json.Marshal(struct{ Amount money.Amount }{Amount: 1050 * money.Cent})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"Amount":10.50}`, string(data))
+
+	// decimal strings and float64 amounts from older payloads decode, rounded to the cent
+	for payload, expected := range map[string]money.Amount{
+		`10.5`:     10*money.Unit + 50,
+		`"10.50"`:  10*money.Unit + 50,
+		`10`:       10 * money.Unit,
+		`37.28491`: 37*money.Unit + 28,
+		`1e6`:      1000000 * money.Unit,
+	} {
+		var a money.Amount
+		require.NoError(t, json.Unmarshal([]byte(payload), &a), payload)
+		require.Equal(t, expected, a, payload)
+	}
+
+	var a money.Amount
+	require.Error(t, json.Unmarshal([]byte(`"ten"`), &a))
+	require.Error(t, json.Unmarshal([]byte(`"10.005"`), &a))
+	a = 10 * money.Unit
+	require.NoError(t, json.Unmarshal([]byte(`null`), &a))
+	require.Zero(t, a)
+}
+
+func TestParseJSON(t *testing.T) {
+	for data, expected := range map[string]money.Amount{
+		`10.5`:    10*money.Unit + 50,
+		`"10.50"`: 10*money.Unit + 50,
+		`10`:      10 * money.Unit,
+		`null`:    0,
+		``:        0,
+	} {
+		a, err := money.ParseJSON([]byte(data))
+		require.NoError(t, err, data)
+		require.Equal(t, expected, a, data)
+	}
+
+	// new input isn't rounded to the cent
+	for _, data := range []string{`10.005`, `"10.005"`, `1e6`, `"ten"`, `true`} {
+		_, err := money.ParseJSON([]byte(data))
+		require.Error(t, err, data)
+	}
+}
+
+func TestAmount_Mul(t *testing.T) {
+	require.Equal(t, 9*money.Unit, (10 * money.Unit).Mul(0.9))
+	require.Equal(t, 1475*money.Unit, (10 * money.Unit).Mul(147.5))
+	// half a cent rounds away from zero
+	require.Equal(t, 1*money.Cent, (1 * money.Cent).Mul(0.5))
+	require.Equal(t, "-0.05", (-5 * money.Cent).String())
+}
+
+func TestAmount_Round(t *testing.T) {
+	require.Equal(t, 12*money.Unit, (1234 * money.Cent).Round(0))
+	require.Equal(t, 13*money.Unit, (1250 * money.Cent).Round(0))
+	require.Equal(t, -13*money.Unit, (-1250 * money.Cent).Round(0))
+	require.Equal(t, 1230*money.Cent, (1234 * money.Cent).Round(1))
+	require.Equal(t, 1234*money.Cent, (1234 * money.Cent).Round(2))
+}
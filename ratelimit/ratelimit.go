@@ -0,0 +1,98 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/time/rate"
+)
+
+// Rule limits the calls made for accounts whose ID starts with Prefix to PerSecond, allowing bursts of up to Burst
+// calls. An empty Prefix matches every account.
+type Rule struct {
+	Prefix    string
+	PerSecond float64
+	Burst     int
+}
+
+type rule struct {
+	prefix  string
+	limiter *rate.Limiter
+}
+
+// Limiter paces calls per account prefix. Every rule has a single budget shared by all the accounts it matches, and a
+// call is paced by the rule with the longest matching prefix only. Calls for accounts no rule matches aren't paced.
+// Budgets live in memory, so they hold per worker: a limit shared by N workers lets N times as many calls through.
+type Limiter struct {
+	rules []rule
+}
+
+func New(rules []Rule) *Limiter {
+	l := &Limiter{}
+	for _, r := range rules {
+		l.rules = append(l.rules, rule{prefix: r.Prefix, limiter: rate.NewLimiter(rate.Limit(r.PerSecond), r.Burst)})
+	}
+	sort.SliceStable(l.rules, func(i, j int) bool {
+		return len(l.rules[i].prefix) > len(l.rules[j].prefix)
+	})
+	return l
+}
+
+// Wait blocks until a call for accountID is allowed, or returns an error if ctx is done first or its deadline is too
+// close to wait that long. A nil Limiter never waits.
+func (l *Limiter) Wait(ctx context.Context, accountID string) error {
+	if l == nil {
+		return nil
+	}
+	for _, r := range l.rules {
+		if strings.HasPrefix(accountID, r.prefix) {
+			if err := r.limiter.Wait(ctx); err != nil {
+				return fmt.Errorf("rate limit for %q: %w", r.prefix, err)
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// ParseRules reads rules written as comma-separated prefix=rate pairs, like "piggy=1,from-account=20,*=50". The
+// prefix "*" matches every account. A rate can be followed by "/burst"; otherwise the burst is the rate rounded up.
+func ParseRules(s string) ([]Rule, error) {
+	var rules []Rule
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		prefix, limit, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid rate limit (%v): want prefix=rate", pair)
+		}
+		prefix = strings.TrimSpace(prefix)
+		if prefix == "*" {
+			prefix = ""
+		}
+		perSecondStr, burstStr, hasBurst := strings.Cut(strings.TrimSpace(limit), "/")
+		perSecond, err := strconv.ParseFloat(perSecondStr, 64)
+		if err != nil || perSecond <= 0 || math.IsInf(perSecond, 0) {
+			return nil, fmt.Errorf("invalid rate limit (%v): rate must be a positive number", pair)
+		}
+		burst := int(math.Ceil(perSecond))
+		if hasBurst {
+			if burst, err = strconv.Atoi(burstStr); err != nil || burst < 1 {
+				return nil, fmt.Errorf("invalid rate limit (%v): burst must be a positive integer", pair)
+			}
+		}
+		for _, r := range rules {
+			if r.Prefix == prefix {
+				return nil, fmt.Errorf("invalid rate limit (%v): prefix set twice", pair)
+			}
+		}
+		rules = append(rules, Rule{Prefix: prefix, PerSecond: perSecond, Burst: burst})
+	}
+	return rules, nil
+}
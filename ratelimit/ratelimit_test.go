@@ -0,0 +1,63 @@
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"replay-demo/ratelimit"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ratelimit.ParseRules("piggy=1, from-account=2.5,*=50/10")
+	require.NoError(t, err)
+	require.Equal(t, []ratelimit.Rule{
+		{Prefix: "piggy", PerSecond: 1, Burst: 1},
+		{Prefix: "from-account", PerSecond: 2.5, Burst: 3},
+		{Prefix: "", PerSecond: 50, Burst: 10},
+	}, rules)
+
+	rules, err = ratelimit.ParseRules("")
+	require.NoError(t, err)
+	require.Empty(t, rules)
+
+	for _, s := range []string{"piggy", "piggy=0", "piggy=fast", "piggy=1/0", "piggy=1,piggy=2"} {
+		_, err := ratelimit.ParseRules(s)
+		require.Error(t, err, s)
+	}
+}
+
+func TestLimiter_LongestPrefixPaces(t *testing.T) {
+	limiter := ratelimit.New([]ratelimit.Rule{
+		{Prefix: "", PerSecond: 1000, Burst: 1000},
+		{Prefix: "piggy", PerSecond: 10, Burst: 1},
+	})
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		require.NoError(t, limiter.Wait(ctx, "piggy-bank"))
+	}
+	// the burst lets the first call through, the other three wait 100ms each
+	require.GreaterOrEqual(t, time.Since(start), 250*time.Millisecond)
+
+	// other accounts draw from the default budget, unaffected by piggy's
+	start = time.Now()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, limiter.Wait(ctx, "from-account-1"))
+	}
+	require.Less(t, time.Since(start), 100*time.Millisecond)
+}
+
+func TestLimiter_WaitGivesUpAtDeadline(t *testing.T) {
+	limiter := ratelimit.New([]ratelimit.Rule{{Prefix: "piggy", PerSecond: 0.1, Burst: 1}})
+	require.NoError(t, limiter.Wait(context.Background(), "piggy-bank"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.Error(t, limiter.Wait(ctx, "piggy-bank"))
+	// unmatched accounts and a nil limiter never wait
+	require.NoError(t, limiter.Wait(ctx, "from-account-1"))
+	require.NoError(t, (*ratelimit.Limiter)(nil).Wait(ctx, "piggy-bank"))
+}
@@ -2,7 +2,7 @@ package schedule
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"time"
 
 	"go.temporal.io/api/enums/v1"
@@ -11,18 +11,19 @@ import (
 	"replay-demo/workflows"
 )
 
-func CreateSchedule(c client.ScheduleClient, scheduleID, workflowID string, spec client.ScheduleSpec, triggerNow bool) {
-	action := &client.ScheduleWorkflowAction{
-		ID:        workflowID,
-		Workflow:  workflows.BatchTransferWorkflow,
-		TaskQueue: "demo-tq",
+const (
+	// generatedBatchRunTimeout is short so we don't accumulate too many concurrent running workflows from the 5s
+	// schedule if the demo worker is down while we allow all overlap runs. Generated batches are a few transfers.
+	generatedBatchRunTimeout = 30 * time.Second
+	// fileBatchExecutionTimeout bounds a batch read from a file across all of its runs. A file may hold thousands of
+	// transfers, each of which may wait up to an hour on a throttled bank, so it gets a day instead of a run timeout.
+	fileBatchExecutionTimeout = 24 * time.Hour
+)
 
-		// Set short timeout so we don't accumulate too many concurrent running workflows from 5s schedule if demo worker
-		// is down while we allow all overlap runs.
-		WorkflowRunTimeout: 30 * time.Second,
-	}
+func CreateSchedule(ctx context.Context, logger *slog.Logger, c client.ScheduleClient, scheduleID, workflowID, taskQueue string, spec client.ScheduleSpec, triggerNow bool, options workflows.BatchTransferOptions) {
+	action := newScheduleAction(workflowID, taskQueue, options)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 	_, err := c.Create(ctx, client.ScheduleOptions{
 		ID:     scheduleID,
@@ -36,15 +37,31 @@ func CreateSchedule(c client.ScheduleClient, scheduleID, workflowID string, spec
 	})
 
 	if err == temporal.ErrScheduleAlreadyRunning {
-		log.Printf("Schedule %v already registered.", scheduleID)
+		logger.InfoContext(ctx, "Schedule already registered", "ScheduleID", scheduleID)
 	} else if err != nil {
-		log.Printf("Failed to create schedule: %v", err)
+		logger.ErrorContext(ctx, "Unable to create schedule", "ScheduleID", scheduleID, "Error", err)
 	} else {
-		log.Printf("Schedule %v created.", scheduleID)
+		logger.InfoContext(ctx, "Schedule created", "ScheduleID", scheduleID)
 	}
 	return
 }
 
+// newScheduleAction starts a BatchTransferWorkflow with options, timed out according to where its transfers come from.
+func newScheduleAction(workflowID, taskQueue string, options workflows.BatchTransferOptions) *client.ScheduleWorkflowAction {
+	action := &client.ScheduleWorkflowAction{
+		ID:        workflowID,
+		Workflow:  workflows.BatchTransferWorkflow,
+		Args:      []interface{}{options},
+		TaskQueue: taskQueue,
+	}
+	if options.Source == "" {
+		action.WorkflowRunTimeout = generatedBatchRunTimeout
+	} else {
+		action.WorkflowExecutionTimeout = fileBatchExecutionTimeout
+	}
+	return action
+}
+
 func MakeSpecEvery5Seconds() client.ScheduleSpec {
 	return client.ScheduleSpec{
 		// Run the schedule every 5s
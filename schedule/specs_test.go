@@ -0,0 +1,48 @@
+package schedule_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/client"
+	"replay-demo/schedule"
+	"replay-demo/workflows"
+)
+
+// recordingScheduleClient records the schedules it is asked to create.
+type recordingScheduleClient struct {
+	client.ScheduleClient
+	created []client.ScheduleOptions
+}
+
+func (c *recordingScheduleClient) Create(ctx context.Context, options client.ScheduleOptions) (client.ScheduleHandle, error) {
+	c.created = append(c.created, options)
+	return nil, nil
+}
+
+func TestCreateSchedule_Timeouts(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	c := &recordingScheduleClient{}
+
+	schedule.CreateSchedule(context.Background(), logger, c, "generated", "payment-generated", "demo-tq",
+		schedule.MakeSpecEvery5Seconds(), false, workflows.BatchTransferOptions{})
+	schedule.CreateSchedule(context.Background(), logger, c, "file", "payment-file", "demo-tq",
+		schedule.MakeSpecEvery5Seconds(), false, workflows.BatchTransferOptions{Source: "testdata/batches"})
+	require.Len(t, c.created, 2)
+
+	// generated batches are small, and must not pile up while the worker is down
+	generated := c.created[0].Action.(*client.ScheduleWorkflowAction)
+	require.Equal(t, "payment-generated", generated.ID)
+	require.Equal(t, 30*time.Second, generated.WorkflowRunTimeout)
+	require.Zero(t, generated.WorkflowExecutionTimeout)
+
+	// a batch read from a file may take hours, across several runs
+	file := c.created[1].Action.(*client.ScheduleWorkflowAction)
+	require.Zero(t, file.WorkflowRunTimeout)
+	require.Equal(t, 24*time.Hour, file.WorkflowExecutionTimeout)
+	require.Equal(t, []interface{}{workflows.BatchTransferOptions{Source: "testdata/batches"}}, file.Args)
+}
@@ -4,14 +4,20 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/rs/cors"
 	"go.temporal.io/sdk/client"
 	demo "replay-demo/client"
+	"replay-demo/config"
+	"replay-demo/logging"
+	"replay-demo/metrics"
+	"replay-demo/money"
 	"replay-demo/schedule"
+	"replay-demo/tracing"
 	"replay-demo/workflows"
 )
 
@@ -42,70 +48,113 @@ func returnSuccess(w http.ResponseWriter) {
 type TransferRequestWithIDs struct {
 	FromAccount string
 	ToAccount   string
-	Amount      float64
-	WorkflowID  string
-	RunID       string
+	// Amount is read with money.ParseJSON, so an amount with more than two decimals is rejected rather than rounded.
+	Amount     json.RawMessage
+	WorkflowID string
+	RunID      string
 }
 
 func main() {
 	mux := http.NewServeMux()
 
-	c := demo.NewClient()
+	cfg, err := config.Load()
+	if err != nil {
+		logging.Fatal(slog.Default(), "Invalid config", "Error", err)
+	}
+	// Lines of the server and the SDK go to stderr from LOG_LEVEL up, with the request ID of the request they concern.
+	logger := logging.New(cfg)
+	// The server's requests, and those it makes to Temporal, are served for Prometheus as /metrics.
+	prometheus := metrics.NewPrometheus()
+	defer prometheus.Close()
+	// Each request is traced, through the updates it sends, into the worker. The spans go to OTEL_TRACES_EXPORTER.
+	tracerProvider, err := tracing.NewTracerProvider(context.Background(), cfg, "replay-demo-server")
+	if err != nil {
+		logging.Fatal(logger, "Unable to set up tracing", "Error", err)
+	}
+	defer tracerProvider.Shutdown(context.Background())
+	interceptors, err := tracing.NewInterceptors(tracerProvider)
+	if err != nil {
+		logging.Fatal(logger, "Unable to set up tracing", "Error", err)
+	}
+	c, err := demo.NewClientWithTelemetry(cfg, demo.Telemetry{
+		Logger:         logger,
+		MetricsHandler: prometheus.MetricsHandler(),
+		Interceptors:   interceptors,
+	})
+	if err != nil {
+		logging.Fatal(logger, "Unable to create client", "Error", err)
+	}
 	defer c.Close()
 
+	// Transfers started from the UI are abandoned after TRANSFER_INACTIVITY_TIMEOUT (e.g. "5m") without user input.
+	transferOptions := workflows.TransferWorkflowOptions{InactivityTimeout: cfg.TransferInactivityTimeout}
+
 	mux.HandleFunc("/initiate", func(w http.ResponseWriter, r *http.Request) {
 		t := time.Now().Unix()
 
-		we, err := c.ExecuteWorkflow(context.Background(), client.StartWorkflowOptions{
+		we, err := c.ExecuteWorkflow(r.Context(), client.StartWorkflowOptions{
 			ID:        "transfer-" + fmt.Sprint(t),
-			TaskQueue: "demo-tq",
-		}, workflows.TransferWorkflow)
+			TaskQueue: cfg.TaskQueue,
+		}, workflows.TransferWorkflow, transferOptions)
 
 		if err != nil {
-			log.Printf("error start wf: %v", err)
+			logger.ErrorContext(r.Context(), "Unable to start transfer", "Error", err)
 			returnError(err, w)
 			return
 		}
 
+		logger.DebugContext(logging.WithWorkflow(r.Context(), we.GetID(), we.GetRunID()), "Started transfer")
 		returnWorkflowIds(we.GetID(), we.GetRunID(), w)
 	})
 
 	handleFunc := func(w http.ResponseWriter, r *http.Request, updateName string) {
 		decoder := json.NewDecoder(r.Body)
 		var t TransferRequestWithIDs
-		err := decoder.Decode(&t)
-		if err != nil {
-			panic(err)
-		}
-
-		if err != nil {
+		if err := decoder.Decode(&t); err != nil {
 			http.Error(w, "Failed to decode request body", http.StatusBadRequest)
 			return
 		}
 
-		var updateHandle client.WorkflowUpdateHandle
+		var args []interface{}
 		switch updateName {
 		case workflows.SetFromAccountUpdateName:
-			updateHandle, err = c.UpdateWorkflow(context.Background(), t.WorkflowID, t.RunID, updateName, t.FromAccount)
+			args = []interface{}{t.FromAccount}
 		case workflows.SetToAccountUpdateName:
-			updateHandle, err = c.UpdateWorkflow(context.Background(), t.WorkflowID, t.RunID, updateName, t.ToAccount)
+			args = []interface{}{t.ToAccount}
 		case workflows.TransferAmountUpdateName:
-			updateHandle, err = c.UpdateWorkflow(context.Background(), t.WorkflowID, t.RunID, updateName, t.Amount)
+			amount, err := money.ParseJSON(t.Amount)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				returnError(err, w)
+				return
+			}
+			args = []interface{}{amount}
 		}
 
+		// the update is named up front, so its lines here and in the workflow carry the same ID
+		updateID := uuid.NewString()
+		ctx := logging.WithUpdateID(logging.WithWorkflow(r.Context(), t.WorkflowID, t.RunID), updateID)
+		updateHandle, err := c.UpdateWorkflowWithOptions(ctx, &client.UpdateWorkflowWithOptionsRequest{
+			UpdateID:   updateID,
+			WorkflowID: t.WorkflowID,
+			RunID:      t.RunID,
+			UpdateName: updateName,
+			Args:       args,
+		})
 		if err != nil {
-			log.Printf("error update workflow for %v: %v", updateName, err)
+			logger.ErrorContext(ctx, "Unable to update workflow", "UpdateName", updateName, "Error", err)
 			returnError(err, w)
 			return
 		}
 
-		err = updateHandle.Get(context.Background(), nil)
+		err = updateHandle.Get(ctx, nil)
 		if err != nil {
-			log.Printf("error get update result for %v: %v", updateName, err)
+			logger.WarnContext(ctx, "Update rejected", "UpdateName", updateName, "Error", err)
 			returnError(err, w)
 			return
 		}
 
+		logger.DebugContext(ctx, "Update accepted", "UpdateName", updateName)
 		returnSuccess(w)
 	}
 
@@ -121,19 +170,77 @@ func main() {
 		handleFunc(w, r, workflows.TransferAmountUpdateName)
 	})
 
+	mux.HandleFunc("/cancel", func(w http.ResponseWriter, r *http.Request) {
+		handleFunc(w, r, workflows.CancelTransferUpdateName)
+	})
+
+	// queryFunc answers a GET with the result of a workflow query, decoded into status.
+	queryFunc := func(w http.ResponseWriter, r *http.Request, queryName string, status interface{}) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		workflowID := r.URL.Query().Get("workflowID")
+		if workflowID == "" {
+			http.Error(w, "workflowID is required", http.StatusBadRequest)
+			return
+		}
+
+		ctx := logging.WithWorkflow(r.Context(), workflowID, r.URL.Query().Get("runID"))
+		resp, err := c.QueryWorkflow(ctx, workflowID, r.URL.Query().Get("runID"), queryName)
+		if err != nil {
+			logger.ErrorContext(ctx, "Unable to query workflow", "QueryType", queryName, "Error", err)
+			returnError(err, w)
+			return
+		}
+		if err := resp.Get(status); err != nil {
+			logger.ErrorContext(ctx, "Unable to decode query result", "QueryType", queryName, "Error", err)
+			returnError(err, w)
+			return
+		}
+		jsonResp, _ := json.Marshal(status)
+		w.Write(jsonResp)
+	}
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		queryFunc(w, r, workflows.TransferStatusQueryName, &workflows.TransferStatus{})
+	})
+
+	// Batch controls take the batch's WorkflowID (and optionally RunID) in the body, like the transfer updates.
+	mux.HandleFunc("/batch/pause", func(w http.ResponseWriter, r *http.Request) {
+		handleFunc(w, r, workflows.PauseBatchUpdateName)
+	})
+
+	mux.HandleFunc("/batch/resume", func(w http.ResponseWriter, r *http.Request) {
+		handleFunc(w, r, workflows.ResumeBatchUpdateName)
+	})
+
+	mux.HandleFunc("/batch/abort", func(w http.ResponseWriter, r *http.Request) {
+		handleFunc(w, r, workflows.AbortBatchUpdateName)
+	})
+
+	mux.HandleFunc("/batch/status", func(w http.ResponseWriter, r *http.Request) {
+		queryFunc(w, r, workflows.BatchTransferStatusQueryName, &workflows.BatchTransferResult{})
+	})
+
 	mux.HandleFunc("/schedule", func(w http.ResponseWriter, r *http.Request) {
-		// Start a schedule of payment workflows
+		// Start a schedule of payment workflows, reading their batch from the optional source file or directory
+		options := workflows.BatchTransferOptions{Source: r.URL.Query().Get("source")}
 		sClient := c.ScheduleClient()
-		schedule.CreateSchedule(sClient, "schedule_every_5s", "payment_every_5s", schedule.MakeSpecEvery5Seconds(), false)
-		schedule.CreateSchedule(sClient, "schedule_business_hourly", "payment_hourly", schedule.MakeSpecBusinessHoursHourly(), true)
-		schedule.CreateSchedule(sClient, "schedule_custom", "payment_custom_schedule", schedule.MakeSpecCustomSchedule(), false)
+		schedule.CreateSchedule(r.Context(), logger, sClient, "schedule_every_5s", "payment_every_5s", cfg.TaskQueue, schedule.MakeSpecEvery5Seconds(), false, options)
+		schedule.CreateSchedule(r.Context(), logger, sClient, "schedule_business_hourly", "payment_hourly", cfg.TaskQueue, schedule.MakeSpecBusinessHoursHourly(), true, options)
+		schedule.CreateSchedule(r.Context(), logger, sClient, "schedule_custom", "payment_custom_schedule", cfg.TaskQueue, schedule.MakeSpecCustomSchedule(), false, options)
 	})
 
-	handler := cors.Default().Handler(mux)
+	mux.Handle("/metrics", prometheus)
+
+	handler := metrics.InstrumentHTTP(prometheus.MetricsHandler(), mux)
+	handler = tracing.InstrumentHTTP(tracerProvider, logging.InstrumentHTTP(handler))
+	handler = cors.Default().Handler(handler)
 
-	// Start the HTTP server on port 7654
-	fmt.Println("Starting server on :7654")
-	if err := http.ListenAndServe(":7654", handler); err != nil {
-		panic(err)
+	// Start the HTTP server on the configured port
+	logger.Info("Starting server", "Address", cfg.HTTPAddr())
+	if err := http.ListenAndServe(cfg.HTTPAddr(), handler); err != nil {
+		logging.Fatal(logger, "Unable to serve", "Error", err)
 	}
 }
@@ -0,0 +1,86 @@
+// Package tracing follows a transfer across the HTTP server, Temporal and the worker with OpenTelemetry, so one trace
+// runs from the UI's request through the update into the activities moving the money.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.temporal.io/sdk/contrib/opentelemetry"
+	"go.temporal.io/sdk/interceptor"
+	"replay-demo/config"
+)
+
+// instrumentationName names the tracer the spans are started with.
+const instrumentationName = "replay-demo"
+
+// Propagator carries traces over HTTP and Temporal headers, as W3C trace context and baggage.
+var Propagator = opentelemetry.DefaultTextMapPropagator
+
+// NewTracerProvider sends the spans of serviceName to the exporter of cfg. OTEL_SERVICE_NAME and
+// OTEL_RESOURCE_ATTRIBUTES override the service name and add to it. Shut the provider down to flush the last spans.
+func NewTracerProvider(ctx context.Context, cfg config.Config, serviceName string) (*sdktrace.TracerProvider, error) {
+	var options []sdktrace.TracerProviderOption
+	switch cfg.TracesExporter {
+	case config.TracesExporterOTLP:
+		// the endpoint, and whether it is secured, come from the OTEL_EXPORTER_OTLP_* env vars
+		exporter, err := otlptracegrpc.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("create OTLP exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	case config.TracesExporterStdout:
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return nil, fmt.Errorf("create stdout exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithBatcher(exporter))
+	}
+	res, err := resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(attribute.String("service.name", serviceName)),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create resource: %w", err)
+	}
+	return sdktrace.NewTracerProvider(append(options, sdktrace.WithResource(res))...), nil
+}
+
+// NewInterceptors trace the workflows, updates and activities a client starts and a worker runs, with the spans of
+// provider.
+func NewInterceptors(provider trace.TracerProvider) ([]interceptor.ClientInterceptor, error) {
+	tracer, err := opentelemetry.NewTracer(opentelemetry.TracerOptions{
+		Tracer:            provider.Tracer(instrumentationName),
+		TextMapPropagator: Propagator,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []interceptor.ClientInterceptor{
+		interceptor.NewTracingInterceptor(tracer),
+		// the SDK doesn't trace updates yet
+		&updateTracingInterceptor{tracer: tracer},
+	}, nil
+}
+
+// InstrumentHTTP traces the requests handler serves, continuing the trace of the caller when it sends one. The spans
+// are named after the method and path of the request.
+func InstrumentHTTP(provider trace.TracerProvider, handler http.Handler) http.Handler {
+	return otelhttp.NewHandler(handler, "",
+		otelhttp.WithTracerProvider(provider),
+		otelhttp.WithPropagators(Propagator),
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + r.URL.Path
+		}),
+	)
+}
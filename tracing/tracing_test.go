@@ -0,0 +1,130 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/worker"
+	"google.golang.org/grpc"
+	"replay-demo/money"
+	"replay-demo/tracing"
+	"replay-demo/workflows"
+)
+
+// newInterceptors trace with a provider whose spans end up in the returned recorder.
+func newInterceptors(t *testing.T) (*tracetest.SpanRecorder, *sdktrace.TracerProvider, []interceptor.ClientInterceptor) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	interceptors, err := tracing.NewInterceptors(provider)
+	require.NoError(t, err)
+	return recorder, provider, interceptors
+}
+
+// span is the ended span named name.
+func span(t *testing.T, recorder *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	for _, s := range recorder.Ended() {
+		if s.Name() == name {
+			return s
+		}
+	}
+	require.Failf(t, "span not found", "no span named %v", name)
+	return nil
+}
+
+func TestInstrumentHTTP_UpdateWorkflow(t *testing.T) {
+	recorder, provider, interceptors := newInterceptors(t)
+	// the update never reaches Temporal: its request is kept for the test instead
+	var updateRequest *workflowservice.UpdateWorkflowExecutionRequest
+	c, err := client.NewLazyClient(client.Options{
+		HostPort:     "localhost:7233",
+		Interceptors: interceptors,
+		ConnectionOptions: client.ConnectionOptions{DialOptions: []grpc.DialOption{grpc.WithUnaryInterceptor(
+			func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+				if r, ok := req.(*workflowservice.UpdateWorkflowExecutionRequest); ok {
+					updateRequest = r
+					return errors.New("not sent")
+				}
+				return nil
+			},
+		)}},
+	})
+	require.NoError(t, err)
+	defer c.Close()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/amount", func(w http.ResponseWriter, r *http.Request) {
+		_, err := c.UpdateWorkflow(r.Context(), "transfer-1", "", workflows.TransferAmountUpdateName, 10*money.Unit)
+		require.ErrorContains(t, err, "not sent")
+	})
+
+	tracing.InstrumentHTTP(provider, mux).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/amount", nil))
+
+	request := span(t, recorder, "POST /amount")
+	update := span(t, recorder, "UpdateWorkflow:"+workflows.TransferAmountUpdateName)
+	require.Equal(t, request.SpanContext().SpanID(), update.Parent().SpanID())
+	require.Equal(t, request.SpanContext().TraceID(), update.SpanContext().TraceID())
+	// the workflow continues the trace from the header of the update
+	require.NotNil(t, updateRequest)
+	var data map[string]string
+	require.NoError(t, converter.GetDefaultDataConverter().FromPayload(
+		updateRequest.GetRequest().GetInput().GetHeader().GetFields()["_tracer-data"], &data))
+	require.Contains(t, data["traceparent"], update.SpanContext().SpanID().String())
+}
+
+type updateCallback struct{}
+
+func (*updateCallback) Accept()                     {}
+func (*updateCallback) Reject(error)                {}
+func (*updateCallback) Complete(interface{}, error) {}
+
+func TestUpdate_ActivitiesAreChildren(t *testing.T) {
+	recorder, _, interceptors := newInterceptors(t)
+	var workerInterceptors []interceptor.WorkerInterceptor
+	for _, i := range interceptors {
+		workerInterceptors = append(workerInterceptors, i.(interceptor.WorkerInterceptor))
+	}
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.SetWorkerOptions(worker.Options{Interceptors: workerInterceptors})
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	env.RegisterActivity(&workflows.TransferActivity{Bank: workflows.NewInMemoryBankGateway(100 * money.Unit)})
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.TransferUpdateName, "transfer", &updateCallback{}, workflows.TransferRequest{
+			FromAccount: "my-from-account",
+			ToAccount:   "my-to-account",
+			Amount:      10 * money.Unit,
+		})
+	}, time.Second)
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
+	require.NoError(t, env.GetWorkflowError())
+
+	update := span(t, recorder, "HandleUpdate:"+workflows.TransferUpdateName)
+	require.Equal(t, "transfer", attribute(update, "temporalUpdateID"))
+	for _, activity := range []string{"Withdraw", "Deposit"} {
+		start := span(t, recorder, "StartActivity:"+activity)
+		require.Equal(t, update.SpanContext().SpanID(), start.Parent().SpanID())
+		run := span(t, recorder, "RunActivity:"+activity)
+		require.Equal(t, start.SpanContext().SpanID(), run.Parent().SpanID())
+		require.Equal(t, update.SpanContext().TraceID(), run.SpanContext().TraceID())
+	}
+}
+
+func attribute(s sdktrace.ReadOnlySpan, key string) string {
+	for _, kv := range s.Attributes() {
+		if string(kv.Key) == key {
+			return kv.Value.AsString()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+package tracing
+
+import (
+	"context"
+	"time"
+
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/workflow"
+)
+
+// The span tags, as the SDK's tracing interceptor names them.
+const (
+	workflowIDTagKey = "temporalWorkflowID"
+	runIDTagKey      = "temporalRunID"
+	updateIDTagKey   = "temporalUpdateID"
+)
+
+// updateTracingInterceptor traces updates like the SDK traces signals: the client sends the update with its span in
+// the header, and the workflow handles it in a child span, which the activities the handler runs are children of.
+type updateTracingInterceptor struct {
+	interceptor.InterceptorBase
+	tracer interceptor.Tracer
+}
+
+func (i *updateTracingInterceptor) InterceptClient(
+	next interceptor.ClientOutboundInterceptor,
+) interceptor.ClientOutboundInterceptor {
+	o := &updateClientOutboundInterceptor{root: i}
+	o.Next = next
+	return o
+}
+
+func (i *updateTracingInterceptor) InterceptWorkflow(
+	ctx workflow.Context,
+	next interceptor.WorkflowInboundInterceptor,
+) interceptor.WorkflowInboundInterceptor {
+	in := &updateWorkflowInboundInterceptor{root: i}
+	in.Next = next
+	return in
+}
+
+type updateClientOutboundInterceptor struct {
+	interceptor.ClientOutboundInterceptorBase
+	root *updateTracingInterceptor
+}
+
+func (o *updateClientOutboundInterceptor) UpdateWorkflow(
+	ctx context.Context,
+	in *interceptor.ClientUpdateWorkflowInput,
+) (client.WorkflowUpdateHandle, error) {
+	tracer := o.root.tracer
+	span, err := tracer.StartSpan(&interceptor.TracerStartSpanOptions{
+		Parent:    tracer.SpanFromContext(ctx),
+		Operation: "UpdateWorkflow",
+		Name:      in.UpdateName,
+		Tags: map[string]string{
+			workflowIDTagKey: in.WorkflowID,
+			updateIDTagKey:   in.UpdateID,
+		},
+		Time: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var finishOpts interceptor.TracerFinishSpanOptions
+	defer span.Finish(&finishOpts)
+	if err := o.root.writeSpanToHeader(span, interceptor.Header(ctx)); err != nil {
+		return nil, err
+	}
+
+	handle, err := o.Next.UpdateWorkflow(tracer.ContextWithSpan(ctx, span), in)
+	finishOpts.Error = err
+	return handle, err
+}
+
+type updateWorkflowInboundInterceptor struct {
+	interceptor.WorkflowInboundInterceptorBase
+	root *updateTracingInterceptor
+}
+
+func (in *updateWorkflowInboundInterceptor) ExecuteUpdate(
+	ctx workflow.Context,
+	input *interceptor.UpdateInput,
+) (interface{}, error) {
+	if workflow.IsReplaying(ctx) {
+		return in.Next.ExecuteUpdate(ctx, input)
+	}
+	tracer := in.root.tracer
+	parent, err := in.root.readSpanFromHeader(interceptor.WorkflowHeader(ctx))
+	if err != nil {
+		return nil, err
+	}
+	info := workflow.GetInfo(ctx)
+	tags := map[string]string{
+		workflowIDTagKey: info.WorkflowExecution.ID,
+		runIDTagKey:      info.WorkflowExecution.RunID,
+	}
+	if updateInfo := workflow.GetUpdateInfo(ctx); updateInfo != nil {
+		tags[updateIDTagKey] = updateInfo.ID
+	}
+	span, err := tracer.StartSpan(&interceptor.TracerStartSpanOptions{
+		Parent:    parent,
+		Operation: "HandleUpdate",
+		Name:      input.Name,
+		Tags:      tags,
+		Time:      time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var finishOpts interceptor.TracerFinishSpanOptions
+	defer span.Finish(&finishOpts)
+
+	// the SDK's interceptor starts the spans of the activities as children of the span under this key
+	ctx = workflow.WithValue(ctx, tracer.Options().SpanContextKey, span)
+	ret, err := in.Next.ExecuteUpdate(ctx, input)
+	finishOpts.Error = err
+	return ret, err
+}
+
+func (i *updateTracingInterceptor) readSpanFromHeader(header map[string]*commonpb.Payload) (interceptor.TracerSpanRef, error) {
+	payload := header[i.tracer.Options().HeaderKey]
+	if payload == nil {
+		return nil, nil
+	}
+	var data map[string]string
+	if err := converter.GetDefaultDataConverter().FromPayload(payload, &data); err != nil {
+		return nil, err
+	}
+	return i.tracer.UnmarshalSpan(data)
+}
+
+func (i *updateTracingInterceptor) writeSpanToHeader(span interceptor.TracerSpan, header map[string]*commonpb.Payload) error {
+	data, err := i.tracer.MarshalSpan(span)
+	if err != nil || len(data) == 0 || header == nil {
+		return err
+	}
+	payload, err := converter.GetDefaultDataConverter().ToPayload(data)
+	if err != nil {
+		return err
+	}
+	header[i.tracer.Options().HeaderKey] = payload
+	return nil
+}
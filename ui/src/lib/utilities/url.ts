@@ -1,4 +1,5 @@
-export const apiUrl = 'http://127.0.0.1:7654';
+// Point VITE_API_URL at the HTTP server when it doesn't listen on the default port.
+export const apiUrl = import.meta.env.VITE_API_URL ?? 'http://127.0.0.1:7654';
 
 export const APIRoutes = {
   initiate: `${apiUrl}/initiate`,
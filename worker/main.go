@@ -2,51 +2,118 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
+	"net/http"
 	"strings"
 
 	"replay-demo/client"
+	"replay-demo/config"
+	"replay-demo/ledger"
+	"replay-demo/logging"
+	"replay-demo/metrics"
+	"replay-demo/ratelimit"
+	"replay-demo/tracing"
 	"replay-demo/workflows"
 
 	"go.temporal.io/api/workflowservice/v1"
+	sdkclient "go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/worker"
 )
 
 // BuildID is used for versioning. Update this whenever there is non-backward compatible workflow logic change.
 // Once deployed with new BuildID, we need to update TaskQueue to set this new buildID as default so new workflow
 // can be route to worker wit this build ID.
-// Example: `temporal task-queue update-build-ids add-new-default --task-queue demo-tq --build-id 2.0`
+// Example: `temporal task-queue update-build-ids add-new-default --task-queue demo-tq --build-id 2.0`, with the task
+// queue of the deployment.
 const BuildID = "1.0"
 
 func main() {
-	c := client.NewClient()
+	cfg, err := config.Load()
+	if err != nil {
+		logging.Fatal(slog.Default(), "Invalid config", "Error", err)
+	}
+	// Lines of the worker, the SDK and the workflows go to stderr from LOG_LEVEL up.
+	logger := logging.New(cfg)
+	// The SDK's metrics, along with those of the transfers and batches, are served for Prometheus on METRICS_PORT.
+	prometheus := metrics.NewPrometheus()
+	defer prometheus.Close()
+	// The spans of the workflows, updates and activities go to OTEL_TRACES_EXPORTER.
+	tracerProvider, err := tracing.NewTracerProvider(context.Background(), cfg, "replay-demo-worker")
+	if err != nil {
+		logging.Fatal(logger, "Unable to set up tracing", "Error", err)
+	}
+	defer tracerProvider.Shutdown(context.Background())
+	interceptors, err := tracing.NewInterceptors(tracerProvider)
+	if err != nil {
+		logging.Fatal(logger, "Unable to set up tracing", "Error", err)
+	}
+	c, err := client.NewClientWithTelemetry(cfg, client.Telemetry{
+		Logger:         logger,
+		MetricsHandler: prometheus.MetricsHandler(),
+		Interceptors:   interceptors,
+	})
+	if err != nil {
+		logging.Fatal(logger, "Unable to create client", "Error", err)
+	}
 	defer c.Close()
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", prometheus)
+	go func() {
+		if err := http.ListenAndServe(cfg.MetricsAddr(), metricsMux); err != nil {
+			logging.Fatal(logger, "Unable to serve metrics", "Error", err)
+		}
+	}()
 
 	// Set current worker as default. This is for demo convenience so this worker will always be the default version.
 	// WARNING: DO NOT DO THIS IN PROD. Should set the default BuildID as part of deployment flow.
 	// Doing this in worker code in prod will cause issue because older version worker may restart and would cause
 	// the older version to be set as default again.
-	SetCurrentWorkerAsDefault()
+	SetCurrentWorkerAsDefault(logger, c, cfg)
 
-	w := worker.New(c, "demo-tq", worker.Options{BuildID: BuildID, UseBuildIDForVersioning: true})
+	w := worker.New(c, cfg.TaskQueue, worker.Options{BuildID: BuildID, UseBuildIDForVersioning: true})
 	w.RegisterWorkflow(workflows.TransferWorkflow)
 	w.RegisterWorkflow(workflows.BatchTransferWorkflow)
+	w.RegisterWorkflow(workflows.DailyLimitWorkflow)
+	// Every movement of money is journaled to LEDGER_PATH (default ledger.jsonl).
+	l, err := ledger.Open(cfg.LedgerPath)
+	if err != nil {
+		logging.Fatal(logger, "Unable to open ledger", "Error", err)
+	}
+	defer l.Close()
+
+	// Calls to the bank are paced per account prefix by BANK_RATE_LIMITS, e.g. "piggy=1,from-account=20,*=50".
+	rules, err := ratelimit.ParseRules(cfg.BankRateLimits)
+	if err != nil {
+		logging.Fatal(logger, "Invalid BANK_RATE_LIMITS", "Error", err)
+	}
+
 	a := &workflows.TransferActivity{
 		TemporalClient: c,
+		Bank:           newBankGateway(cfg),
+		Ledger:         l,
+		DailyLimit:     workflows.DailyAmountLimit,
+		RateLimiter:    ratelimit.New(rules),
 	}
 	w.RegisterActivity(a)
-	err := w.Run(worker.InterruptCh())
+	err = w.Run(worker.InterruptCh())
 	if err != nil {
-		log.Fatalln("Unable to start worker", err)
+		logging.Fatal(logger, "Unable to start worker", "Error", err)
 	}
 }
 
-func SetCurrentWorkerAsDefault() {
-	c := client.NewClient()
-	defer c.Close()
+// newBankGateway picks the bank backend from BANK_GATEWAY: "stub" (default) accepts every transfer, "memory" keeps
+// balances in memory, opening every account with BANK_OPENING_BALANCE.
+func newBankGateway(cfg config.Config) workflows.BankGateway {
+	if cfg.BankGateway == config.BankGatewayMemory {
+		return workflows.NewInMemoryBankGateway(cfg.BankOpeningBalance)
+	}
+	return workflows.StubBankGateway{}
+}
+
+func SetCurrentWorkerAsDefault(logger *slog.Logger, c sdkclient.Client, cfg config.Config) {
 	request := &workflowservice.UpdateWorkerBuildIdCompatibilityRequest{
-		Namespace: client.GetNamespace(),
-		TaskQueue: "demo-tq",
+		Namespace: cfg.Namespace,
+		TaskQueue: cfg.TaskQueue,
 		Operation: &workflowservice.UpdateWorkerBuildIdCompatibilityRequest_AddNewBuildIdInNewDefaultSet{
 			AddNewBuildIdInNewDefaultSet: BuildID,
 		},
@@ -55,13 +122,17 @@ func SetCurrentWorkerAsDefault() {
 	_, err := c.WorkflowService().UpdateWorkerBuildIdCompatibility(context.Background(), request)
 	if err != nil && strings.Contains(err.Error(), "already exists") {
 		request := &workflowservice.UpdateWorkerBuildIdCompatibilityRequest{
-			Namespace: client.GetNamespace(),
-			TaskQueue: "demo-tq",
+			Namespace: cfg.Namespace,
+			TaskQueue: cfg.TaskQueue,
 			Operation: &workflowservice.UpdateWorkerBuildIdCompatibilityRequest_PromoteSetByBuildId{
 				PromoteSetByBuildId: BuildID,
 			},
 		}
 		_, err = c.WorkflowService().UpdateWorkerBuildIdCompatibility(context.Background(), request)
 	}
-	log.Printf("Set default buildID: %v, Err: %v\n", BuildID, err)
+	if err != nil {
+		logger.Error("Unable to set default build ID", "BuildID", BuildID, "Error", err)
+		return
+	}
+	logger.Info("Set default build ID", "BuildID", BuildID)
 }
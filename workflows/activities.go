@@ -2,11 +2,18 @@ package workflows
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math/rand"
-	"strings"
 	"time"
 
+	"replay-demo/ledger"
+	"replay-demo/limits"
+	"replay-demo/money"
+	"replay-demo/ratelimit"
+
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
 	"go.temporal.io/sdk/activity"
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/temporal"
@@ -14,59 +21,206 @@ import (
 
 const totalAccountNumber = 10
 
+// Error types of a Transfer that ended without moving money, so the batch can tell them from other failures.
+const (
+	transferRejectedErrorType    = "transfer-rejected"
+	transferCompensatedErrorType = "transfer-compensated"
+	transferFailedErrorType      = "transfer-failed"
+	transferDuplicateErrorType   = "transfer-duplicate"
+)
+
 type TransferActivity struct {
 	TemporalClient client.Client
+	Bank           BankGateway
+	// Ledger records every movement of money. Recording is skipped when it is nil.
+	Ledger *ledger.Ledger
+	// DailyLimit is the most each account can withdraw a day, in BaseCurrency, enforced by a DailyLimitWorkflow per
+	// account and day. Enforcement is skipped when it is zero.
+	DailyLimit money.Amount
+	// FX quotes exchange rates for transfers across currencies. Defaults to ReferenceFXProvider when nil.
+	FX FXProvider
+	// RateLimiter paces calls to the bank by the account they touch. Calls aren't paced when it is nil.
+	RateLimiter *ratelimit.Limiter
+}
+
+// GetFXQuote quotes the rate to convert from into to.
+func (a *TransferActivity) GetFXQuote(ctx context.Context, from, to string) (FXQuote, error) {
+	fx := a.FX
+	if fx == nil {
+		fx = ReferenceFXProvider{}
+	}
+	return fx.Quote(ctx, from, to)
+}
+
+// ReserveDailyLimit reserves amount out of the account's daily limit for the transfer run calling it.
+func (a *TransferActivity) ReserveDailyLimit(ctx context.Context, accountID, day string, amount money.Amount) error {
+	if a.DailyLimit == 0 {
+		return nil
+	}
+	err := a.updateDailyLimit(ctx, accountID, day, ReserveDailyLimitUpdateName, reservationID(ctx), amount)
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) && appErr.Type() == dailyLimitExceededErrorType {
+		return temporal.NewNonRetryableApplicationError(appErr.Error(), dailyLimitExceededErrorType, nil)
+	}
+	return err
+}
+
+// ReleaseDailyLimit gives back the reservation made by ReserveDailyLimit in the same transfer run.
+func (a *TransferActivity) ReleaseDailyLimit(ctx context.Context, accountID, day string) error {
+	if a.DailyLimit == 0 {
+		return nil
+	}
+	return a.updateDailyLimit(ctx, accountID, day, ReleaseDailyLimitUpdateName, reservationID(ctx))
+}
+
+// updateDailyLimit sends an update to the DailyLimitWorkflow of accountID on day, starting it on the task queue of the
+// activity unless it is running. The update ID is made of the update name and the reservation ID, so a retried
+// activity gets the outcome of the update it sent before instead of sending it again.
+func (a *TransferActivity) updateDailyLimit(ctx context.Context, accountID, day, updateName, reservationID string, args ...interface{}) error {
+	workflowID := dailyLimitWorkflowID(accountID, day)
+	_, err := a.TemporalClient.ExecuteWorkflow(ctx, client.StartWorkflowOptions{
+		ID:        workflowID,
+		TaskQueue: activity.GetInfo(ctx).TaskQueue,
+		// the running workflow is returned instead
+		WorkflowIDReusePolicy: enumspb.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE,
+	}, DailyLimitWorkflow, limits.Daily{AccountID: accountID, Day: day, Limit: a.DailyLimit})
+	if err != nil {
+		return err
+	}
+	handle, err := a.TemporalClient.UpdateWorkflowWithOptions(ctx, &client.UpdateWorkflowWithOptionsRequest{
+		UpdateID:   fmt.Sprintf("%s/%s", updateName, reservationID),
+		WorkflowID: workflowID,
+		UpdateName: updateName,
+		Args:       append([]interface{}{reservationID}, args...),
+	})
+	if err != nil {
+		return err
+	}
+	return handle.Get(ctx, nil)
 }
 
-func (a *TransferActivity) Deposit(ctx context.Context, accountID string, amount float64) error {
-	if strings.Contains(strings.ToLower(accountID), "piggy") {
-		return temporal.NewNonRetryableApplicationError("deposit failed: piggy bank account is frozen", "account-frozen", nil)
+func (a *TransferActivity) Deposit(ctx context.Context, accountID string, amount money.Amount, currency string) error {
+	if err := a.RateLimiter.Wait(ctx, accountID); err != nil {
+		return fmt.Errorf("deposit failed: %w", err)
 	}
-	// make bank API call to deposit the amount.
-	return nil
+	if err := a.Bank.Deposit(ctx, idempotencyKey(ctx), accountID, amount, currency); err != nil {
+		return bankError("deposit", err)
+	}
+	return a.record(ctx, ledger.KindDeposit, accountID, currency, amount)
 }
 
-func (a *TransferActivity) Withdraw(ctx context.Context, accountID string, amount float64) error {
-	if strings.Contains(strings.ToLower(accountID), "piggy") {
-		return temporal.NewNonRetryableApplicationError("withdraw failed: piggy bank account is frozen", "account-frozen", nil)
+func (a *TransferActivity) Withdraw(ctx context.Context, accountID string, amount money.Amount, currency string) error {
+	if err := a.RateLimiter.Wait(ctx, accountID); err != nil {
+		return fmt.Errorf("withdraw failed: %w", err)
+	}
+	if err := a.Bank.Withdraw(ctx, idempotencyKey(ctx), accountID, amount, currency); err != nil {
+		return bankError("withdraw", err)
 	}
-	// make bank API call to withdraw the amount.
-	return nil
+	return a.record(ctx, ledger.KindWithdraw, accountID, currency, amount)
 }
 
-func (a *TransferActivity) RevertDeposit(ctx context.Context, accountID string, amount float64) error {
-	// make bank API call to revert deposit.
-	return nil
+// RevertDeposit takes back the deposit made by the Deposit activity with depositActivityID in the same transfer run, if
+// the bank applied it. An empty depositActivityID takes it back regardless, for transfers started before their deposit
+// had a known activity ID.
+func (a *TransferActivity) RevertDeposit(ctx context.Context, accountID string, amount money.Amount, currency, depositActivityID string) error {
+	if applied, err := a.applied(ctx, depositActivityID, accountID); err != nil || !applied {
+		return err
+	}
+	if err := a.RateLimiter.Wait(ctx, accountID); err != nil {
+		return fmt.Errorf("revert deposit failed: %w", err)
+	}
+	if err := a.Bank.Withdraw(ctx, idempotencyKey(ctx), accountID, amount, currency); err != nil {
+		return bankError("revert deposit", err)
+	}
+	return a.record(ctx, ledger.KindRevertDeposit, accountID, currency, amount)
 }
 
-func (a *TransferActivity) RevertWithdraw(ctx context.Context, accountID string, amount float64) error {
-	// make bank API call to revert withdraw
-	return nil
+// RevertWithdraw puts back the withdraw made by the Withdraw activity with withdrawActivityID in the same transfer run,
+// if the bank applied it. An empty withdrawActivityID puts it back regardless, for transfers started before their
+// withdraw had a known activity ID.
+func (a *TransferActivity) RevertWithdraw(ctx context.Context, accountID string, amount money.Amount, currency, withdrawActivityID string) error {
+	if applied, err := a.applied(ctx, withdrawActivityID, accountID); err != nil || !applied {
+		return err
+	}
+	if err := a.RateLimiter.Wait(ctx, accountID); err != nil {
+		return fmt.Errorf("revert withdraw failed: %w", err)
+	}
+	if err := a.Bank.Deposit(ctx, idempotencyKey(ctx), accountID, amount, currency); err != nil {
+		return bankError("revert withdraw", err)
+	}
+	return a.record(ctx, ledger.KindRevertWithdraw, accountID, currency, amount)
 }
 
+// GetBatchTransferRequest loads a whole batch at once. Only batches started before they were paged still call it.
 func (a *TransferActivity) GetBatchTransferRequest(ctx context.Context) ([]TransferRequest, error) {
 	var requests []TransferRequest
 	for i := 1; i <= totalAccountNumber; i++ {
-		requests = append(requests, TransferRequest{
-			FromAccount: fmt.Sprintf("from-account-%v", 1+rand.Intn(50)),
-			ToAccount:   fmt.Sprintf("to-account-%v", 51+rand.Intn(50)),
-			Amount:      10, // hard code amount
-		})
+		requests = append(requests, randomTransferRequest())
 	}
 	return requests, nil
 }
 
+// GetBatchTransferPage reads the page of a batch starting at req.Cursor, from the file or directory req.Source names,
+// or from req.BatchSize random transfers when it is empty.
+func (a *TransferActivity) GetBatchTransferPage(ctx context.Context, req BatchTransferPageRequest) (BatchTransferPage, error) {
+	var source BatchSource = GeneratedBatchSource{Size: req.BatchSize}
+	if req.Source != "" {
+		source = FileBatchSource{Path: req.Source}
+	}
+	page, err := source.Page(ctx, req.Cursor, req.PageSize)
+	if err != nil {
+		// a missing file or a bad cursor won't fix itself on retry
+		return BatchTransferPage{}, temporal.NewNonRetryableApplicationError(fmt.Sprintf("read batch failed: %v", err), "batch-source-failed", err)
+	}
+	return page, nil
+}
+
+func randomTransferRequest() TransferRequest {
+	return TransferRequest{
+		FromAccount: fmt.Sprintf("from-account-%v", 1+rand.Intn(50)),
+		ToAccount:   fmt.Sprintf("to-account-%v", 51+rand.Intn(50)),
+		Amount:      10 * money.Unit, // hard code amount
+	}
+}
+
+// Transfer starts the TransferWorkflow of req on the task queue of the batch, and sends it the request. A transfer
+// whose ID was used already is reported as a duplicate instead. On a retry, the transfer found is taken for the one
+// started by the previous attempt: a running one is sent the request again, the update ID keeping it from being
+// applied twice, and a closed one is only read for how it ended. It heartbeats while it waits for the transfer, which
+// pacing calls to the bank can hold up for long.
 func (a *TransferActivity) Transfer(ctx context.Context, req TransferRequest) (string, error) {
-	workflowID := fmt.Sprintf("%s_%s_%s_$%.2f", activity.GetInfo(ctx).WorkflowExecution.ID, req.FromAccount, req.ToAccount, req.Amount)
+	info := activity.GetInfo(ctx)
+	defer heartbeat(ctx)()
+	workflowID, updateID := req.TransferID, req.TransferID
+	if workflowID == "" {
+		workflowID, updateID = batchTransferWorkflowID(info.WorkflowExecution.ID, req), "batch-transfer-update"
+	}
 	_, err := a.TemporalClient.ExecuteWorkflow(ctx, client.StartWorkflowOptions{
 		ID:        workflowID,
-		TaskQueue: "demo-tq",
-	}, TransferWorkflow)
-	if err != nil {
+		TaskQueue: info.TaskQueue,
+		// a transfer ID is used once, even after its transfer closed
+		WorkflowIDReusePolicy:                    enumspb.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
+		WorkflowExecutionErrorWhenAlreadyStarted: true,
+	}, TransferWorkflow, TransferWorkflowOptions{})
+	var alreadyStarted *serviceerror.WorkflowExecutionAlreadyStarted
+	if errors.As(err, &alreadyStarted) && info.Attempt == 1 {
+		return workflowID, duplicateTransferError(workflowID)
+	}
+	if err != nil && !errors.As(err, &alreadyStarted) {
 		return "", err
 	}
+	if err != nil {
+		// the transfer started by the previous attempt may have closed since, and a closed transfer takes no update
+		closed, err := a.transferClosed(ctx, workflowID)
+		if err != nil {
+			return "", err
+		}
+		if closed {
+			return workflowID, a.transferResult(ctx, workflowID, "")
+		}
+	}
 	handle, err := a.TemporalClient.UpdateWorkflowWithOptions(ctx, &client.UpdateWorkflowWithOptionsRequest{
-		UpdateID:   "batch-transfer-update",
+		UpdateID:   updateID,
 		WorkflowID: workflowID,
 		UpdateName: TransferUpdateName,
 		Args:       []interface{}{req},
@@ -75,13 +229,147 @@ func (a *TransferActivity) Transfer(ctx context.Context, req TransferRequest) (s
 		return "", err
 	}
 
-	err = handle.Get(ctx, nil)
-	// Sleep 1s to slow down
-	time.Sleep(time.Second)
+	if err = handle.Get(ctx, nil); err != nil {
+		err = a.transferError(ctx, workflowID, err)
+	}
 	return workflowID, err
 }
 
-func (a *TransferActivity) GetPaymentAmount(req TransferRequest) (float64, error) {
-	// return random number [1, 100)
-	return 1 + rand.Float64()*99, nil
+// transferError finds out how the transfer behind a failed update ended. A rejected update leaves the transfer waiting
+// for details; otherwise the transfer finishes as soon as its compensations have run. The error keeps the message of
+// the update's error but not its cause, which would repeat it.
+func (a *TransferActivity) transferError(ctx context.Context, workflowID string, updateErr error) error {
+	value, err := a.TemporalClient.QueryWorkflow(ctx, workflowID, "", TransferStatusQueryName)
+	if err != nil {
+		return updateErr
+	}
+	var status TransferStatus
+	if err := value.Get(&status); err != nil {
+		return updateErr
+	}
+	if status.Stage == TransferStageAwaitingDetails {
+		return temporal.NewNonRetryableApplicationError(updateErr.Error(), transferRejectedErrorType, nil)
+	}
+	err = a.transferResult(ctx, workflowID, updateErr.Error())
+	if ctx.Err() != nil {
+		return updateErr
+	}
+	return err
+}
+
+// transferResult waits for the transfer to finish and turns how it ended into the error of the Transfer activity. The
+// error has message, or the last error of the transfer when message is empty.
+func (a *TransferActivity) transferResult(ctx context.Context, workflowID, message string) error {
+	var status TransferStatus
+	if err := a.TemporalClient.GetWorkflow(ctx, workflowID, "").Get(ctx, &status); err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		// the transfer only fails when its compensations did
+		return temporal.NewNonRetryableApplicationError(err.Error(), transferFailedErrorType, nil)
+	}
+	if message == "" {
+		message = status.LastError
+	}
+	if message == "" {
+		message = fmt.Sprintf("transfer %s", status.Stage)
+	}
+	switch status.Stage {
+	case TransferStageCompleted:
+		return nil
+	case TransferStageCompensated:
+		return temporal.NewNonRetryableApplicationError(message, transferCompensatedErrorType, nil)
+	case TransferStageRejected, TransferStageAbandoned:
+		return temporal.NewNonRetryableApplicationError(message, transferRejectedErrorType, nil)
+	}
+	return temporal.NewNonRetryableApplicationError(message, transferFailedErrorType, nil)
+}
+
+// transferClosed tells whether the transfer with workflowID is closed.
+func (a *TransferActivity) transferClosed(ctx context.Context, workflowID string) (bool, error) {
+	resp, err := a.TemporalClient.DescribeWorkflowExecution(ctx, workflowID, "")
+	if err != nil {
+		return false, err
+	}
+	return resp.GetWorkflowExecutionInfo().GetStatus() != enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING, nil
+}
+
+func (a *TransferActivity) GetPaymentAmount(req TransferRequest) (money.Amount, error) {
+	// return random amount [1, 100)
+	return money.FromFloat(1 + rand.Float64()*99), nil
+}
+
+// record writes the journal entry for a step of the transfer, keyed by the workflow execution running it.
+func (a *TransferActivity) record(ctx context.Context, kind ledger.Kind, accountID, currency string, amount money.Amount) error {
+	if a.Ledger == nil {
+		return nil
+	}
+	execution := activity.GetInfo(ctx).WorkflowExecution
+	return a.Ledger.Record(ledger.NewEntry(kind, execution.ID, execution.RunID, accountID, currency, amount))
+}
+
+// applied tells whether the bank applied the call made on accountID by the activity with activityID, in the workflow
+// run of ctx. A call by an unknown activity, with an empty activityID, is taken as applied.
+func (a *TransferActivity) applied(ctx context.Context, activityID, accountID string) (bool, error) {
+	if activityID == "" {
+		return true, nil
+	}
+	applied, err := a.Bank.Applied(ctx, idempotencyKeyOf(ctx, activityID), accountID)
+	if err != nil {
+		return false, fmt.Errorf("check applied failed: %w", err)
+	}
+	return applied, nil
+}
+
+// idempotencyKey identifies the activity call across retries, so the bank can tell a retry from a new request.
+func idempotencyKey(ctx context.Context) string {
+	return idempotencyKeyOf(ctx, activity.GetInfo(ctx).ActivityID)
+}
+
+// idempotencyKeyOf is the idempotency key of the calls made by the activity with activityID, in the workflow run of ctx.
+func idempotencyKeyOf(ctx context.Context, activityID string) string {
+	execution := activity.GetInfo(ctx).WorkflowExecution
+	return fmt.Sprintf("%s/%s/%s", execution.ID, execution.RunID, activityID)
+}
+
+// reservationID identifies the daily limit reservation of a transfer run.
+func reservationID(ctx context.Context) string {
+	execution := activity.GetInfo(ctx).WorkflowExecution
+	return fmt.Sprintf("%s/%s", execution.ID, execution.RunID)
+}
+
+// heartbeat records heartbeats for the activity of ctx, at half its heartbeat timeout, until the returned func is
+// called. It does nothing for an activity without a heartbeat timeout.
+func heartbeat(ctx context.Context) (stop func()) {
+	interval := activity.GetInfo(ctx).HeartbeatTimeout / 2
+	if interval <= 0 {
+		return func() {}
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				activity.RecordHeartbeat(ctx)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// bankError turns business failures reported by the bank into non-retryable errors. Anything else is left retryable.
+func bankError(op string, err error) error {
+	switch {
+	case errors.Is(err, ErrAccountFrozen):
+		return temporal.NewNonRetryableApplicationError(fmt.Sprintf("%s failed: %v", op, err), "account-frozen", err)
+	case errors.Is(err, ErrInsufficientFunds):
+		return temporal.NewNonRetryableApplicationError(fmt.Sprintf("%s failed: %v", op, err), "insufficient-funds", err)
+	}
+	return fmt.Errorf("%s failed: %w", op, err)
 }
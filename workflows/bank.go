@@ -0,0 +1,148 @@
+package workflows
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"replay-demo/money"
+)
+
+var (
+	ErrAccountFrozen     = errors.New("account is frozen")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+)
+
+// BankGateway is the downstream bank API that TransferActivity moves money through. Amounts are in the given
+// currency. Withdraw and Deposit carry an idempotency key: a call repeating the key of an earlier call must not move
+// money again and returns the earlier outcome. Applied tells whether the call with the key moved money on the account,
+// so a call whose outcome was lost can be put back only if it went through.
+type BankGateway interface {
+	Withdraw(ctx context.Context, idempotencyKey, accountID string, amount money.Amount, currency string) error
+	Deposit(ctx context.Context, idempotencyKey, accountID string, amount money.Amount, currency string) error
+	Applied(ctx context.Context, idempotencyKey, accountID string) (bool, error)
+	Balance(ctx context.Context, accountID, currency string) (money.Amount, error)
+}
+
+// StubBankGateway accepts every call without keeping balances, so repeated calls are trivially idempotent. Piggy bank
+// accounts are frozen so the demo can show a failing transfer.
+type StubBankGateway struct{}
+
+func (StubBankGateway) Withdraw(ctx context.Context, idempotencyKey, accountID string, amount money.Amount, currency string) error {
+	if isFrozenAccount(accountID) {
+		return fmt.Errorf("%w: %v", ErrAccountFrozen, accountID)
+	}
+	return nil
+}
+
+func (StubBankGateway) Deposit(ctx context.Context, idempotencyKey, accountID string, amount money.Amount, currency string) error {
+	if isFrozenAccount(accountID) {
+		return fmt.Errorf("%w: %v", ErrAccountFrozen, accountID)
+	}
+	return nil
+}
+
+// Applied takes every call on an account that isn't frozen as applied, as the stub doesn't remember calls.
+func (StubBankGateway) Applied(ctx context.Context, idempotencyKey, accountID string) (bool, error) {
+	return !isFrozenAccount(accountID), nil
+}
+
+func (StubBankGateway) Balance(ctx context.Context, accountID, currency string) (money.Amount, error) {
+	return 0, nil
+}
+
+// InMemoryBankGateway keeps real balances in memory, one per account and currency. Balances are opened lazily with
+// the opening balance the first time they are used.
+type InMemoryBankGateway struct {
+	mu             sync.Mutex
+	openingBalance money.Amount
+	balances       map[holding]money.Amount
+	// outcomes remembers the result of every call by idempotency key.
+	outcomes map[string]error
+}
+
+func NewInMemoryBankGateway(openingBalance money.Amount) *InMemoryBankGateway {
+	return &InMemoryBankGateway{
+		openingBalance: openingBalance,
+		balances:       make(map[holding]money.Amount),
+		outcomes:       make(map[string]error),
+	}
+}
+
+// SetBalance overrides the balance of an account in currency, opening it if needed.
+func (b *InMemoryBankGateway) SetBalance(accountID, currency string, amount money.Amount) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.balances[holding{accountID: accountID, currency: currency}] = amount
+}
+
+func (b *InMemoryBankGateway) Withdraw(ctx context.Context, idempotencyKey, accountID string, amount money.Amount, currency string) error {
+	return b.once(idempotencyKey, func() error {
+		if isFrozenAccount(accountID) {
+			return fmt.Errorf("%w: %v", ErrAccountFrozen, accountID)
+		}
+		h := holding{accountID: accountID, currency: currency}
+		balance := b.balanceLocked(h)
+		if balance < amount {
+			return fmt.Errorf("%w: account %v has %s, needs %s", ErrInsufficientFunds, accountID, formatMoney(balance, currency), formatMoney(amount, currency))
+		}
+		b.balances[h] = balance - amount
+		return nil
+	})
+}
+
+func (b *InMemoryBankGateway) Deposit(ctx context.Context, idempotencyKey, accountID string, amount money.Amount, currency string) error {
+	return b.once(idempotencyKey, func() error {
+		if isFrozenAccount(accountID) {
+			return fmt.Errorf("%w: %v", ErrAccountFrozen, accountID)
+		}
+		h := holding{accountID: accountID, currency: currency}
+		b.balances[h] = b.balanceLocked(h) + amount
+		return nil
+	})
+}
+
+func (b *InMemoryBankGateway) Applied(ctx context.Context, idempotencyKey, accountID string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err, ok := b.outcomes[idempotencyKey]
+	return ok && err == nil, nil
+}
+
+func (b *InMemoryBankGateway) Balance(ctx context.Context, accountID, currency string) (money.Amount, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.balanceLocked(holding{accountID: accountID, currency: currency}), nil
+}
+
+// once runs op under the lock unless idempotencyKey was already used, in which case the earlier outcome is returned.
+func (b *InMemoryBankGateway) once(idempotencyKey string, op func() error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err, ok := b.outcomes[idempotencyKey]; ok {
+		return err
+	}
+	err := op()
+	b.outcomes[idempotencyKey] = err
+	return err
+}
+
+func (b *InMemoryBankGateway) balanceLocked(h holding) money.Amount {
+	balance, ok := b.balances[h]
+	if !ok {
+		balance = b.openingBalance
+		b.balances[h] = balance
+	}
+	return balance
+}
+
+type holding struct {
+	accountID string
+	currency  string
+}
+
+func isFrozenAccount(accountID string) bool {
+	return strings.Contains(strings.ToLower(accountID), "piggy")
+}
@@ -0,0 +1,250 @@
+package workflows
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"replay-demo/money"
+)
+
+// BatchSource reads the transfer requests of a batch a page at a time. The cursor is opaque to the batch: it is empty
+// for the first page, and the next cursor is empty after the last page.
+type BatchSource interface {
+	Page(ctx context.Context, cursor string, pageSize int) (BatchTransferPage, error)
+}
+
+// GeneratedBatchSource makes up Size random transfers. The cursor is the offset of the page in the batch.
+type GeneratedBatchSource struct {
+	Size int
+}
+
+func (s GeneratedBatchSource) Page(ctx context.Context, cursor string, pageSize int) (BatchTransferPage, error) {
+	offset := 0
+	if cursor != "" {
+		var err error
+		if offset, err = strconv.Atoi(cursor); err != nil {
+			return BatchTransferPage{}, fmt.Errorf("invalid cursor (%v): %w", cursor, err)
+		}
+	}
+	end := min(offset+pageSize, s.Size)
+	var page BatchTransferPage
+	for i := offset; i < end; i++ {
+		page.Requests = append(page.Requests, randomTransferRequest())
+	}
+	if end < s.Size {
+		page.NextCursor = strconv.Itoa(end)
+	}
+	return page, nil
+}
+
+// FileBatchSource reads transfers from a .jsonl or .csv file, or from every such file of a directory in name order.
+//
+// A JSONL file holds one TransferRequest per line. A CSV file starts with a header naming the TransferRequest field of
+// each column (FromAccount, ToAccount, Amount and optionally TransferID, Currency and ToCurrency, in any case and with
+// or without underscores, like from_account); quoted fields can't span lines. Blank lines are skipped. A line that
+// can't be read or fails validation doesn't stop the batch: it is reported in the page as an invalid line.
+//
+// The cursor is the file name and the number of the next line to read, as "name:line".
+type FileBatchSource struct {
+	Path string
+}
+
+func (s FileBatchSource) Page(ctx context.Context, cursor string, pageSize int) (BatchTransferPage, error) {
+	dir, files, err := s.files()
+	if err != nil {
+		return BatchTransferPage{}, err
+	}
+	start, line := 0, 1
+	if cursor != "" {
+		name, lineStr, ok := strings.Cut(cursor, ":")
+		if ok {
+			line, err = strconv.Atoi(lineStr)
+		}
+		if !ok || err != nil || line < 1 {
+			return BatchTransferPage{}, fmt.Errorf("invalid cursor (%v)", cursor)
+		}
+		start = sort.SearchStrings(files, name)
+		if start == len(files) || files[start] != name {
+			return BatchTransferPage{}, fmt.Errorf("invalid cursor (%v): no file %v in %v", cursor, name, s.Path)
+		}
+	}
+
+	var page BatchTransferPage
+	for i := start; i < len(files); i++ {
+		next, err := readBatchFile(dir, files[i], line, pageSize, &page)
+		if err != nil {
+			return BatchTransferPage{}, err
+		}
+		if next > 0 {
+			page.NextCursor = fmt.Sprintf("%s:%d", files[i], next)
+			return page, nil
+		}
+		line = 1
+	}
+	return page, nil
+}
+
+// files returns the directory of the source and the names of the files to read in it, sorted.
+func (s FileBatchSource) files() (string, []string, error) {
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	if !info.IsDir() {
+		if !isBatchFile(s.Path) {
+			return "", nil, fmt.Errorf("unsupported batch file (%v): want .jsonl or .csv", s.Path)
+		}
+		return filepath.Dir(s.Path), []string{filepath.Base(s.Path)}, nil
+	}
+	entries, err := os.ReadDir(s.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && isBatchFile(entry.Name()) {
+			files = append(files, entry.Name())
+		}
+	}
+	// ReadDir sorts by file name already
+	return s.Path, files, nil
+}
+
+// readBatchFile reads the lines of a batch file from line on into page until it holds pageSize entries. It returns
+// the number of the next line to read, or 0 once the file is done.
+func readBatchFile(dir, name string, line, pageSize int, page *BatchTransferPage) (int, error) {
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	isCSV := strings.EqualFold(filepath.Ext(name), ".csv")
+	var header []string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		if isCSV && header == nil {
+			if header, err = readCSVHeader(text); err != nil {
+				return 0, fmt.Errorf("%s:%d: %w", name, n, err)
+			}
+			continue
+		}
+		if n < line {
+			continue
+		}
+		if len(page.Requests)+len(page.Invalid) == pageSize {
+			return n, nil
+		}
+
+		var req TransferRequest
+		if isCSV {
+			req, err = parseCSVLine(header, text)
+		} else {
+			req, err = parseJSONLine(text)
+		}
+		if err == nil {
+			err = req.withDefaultCurrencies().validate()
+		}
+		if err != nil {
+			page.Invalid = append(page.Invalid, InvalidBatchLine{Line: fmt.Sprintf("%s:%d", name, n), Error: err.Error()})
+			continue
+		}
+		page.Requests = append(page.Requests, req)
+	}
+	return 0, scanner.Err()
+}
+
+func isBatchFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	return ext == ".jsonl" || ext == ".csv"
+}
+
+func parseJSONLine(text string) (TransferRequest, error) {
+	// the amount is read like in a CSV line, so a sub-cent amount is rejected rather than rounded
+	var line struct {
+		TransferRequest
+		Amount json.RawMessage
+	}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(text)))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&line); err != nil {
+		return TransferRequest{}, fmt.Errorf("invalid transfer request: %w", err)
+	}
+	req := line.TransferRequest
+	var err error
+	if req.Amount, err = money.ParseJSON(line.Amount); err != nil {
+		return TransferRequest{}, fmt.Errorf("invalid transfer amount (%s): %w", line.Amount, err)
+	}
+	return req, nil
+}
+
+var csvColumns = []string{"TransferID", "FromAccount", "ToAccount", "Amount", "Currency", "ToCurrency"}
+
+// readCSVHeader returns the canonical field name of each column.
+func readCSVHeader(text string) ([]string, error) {
+	fields, err := csv.NewReader(strings.NewReader(text)).Read()
+	if err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+	header := make([]string, len(fields))
+	for i, field := range fields {
+		for _, column := range csvColumns {
+			if strings.EqualFold(strings.ReplaceAll(strings.TrimSpace(field), "_", ""), column) {
+				header[i] = column
+			}
+		}
+		if header[i] == "" {
+			return nil, fmt.Errorf("invalid header: unknown column (%v)", field)
+		}
+	}
+	return header, nil
+}
+
+func parseCSVLine(header []string, text string) (TransferRequest, error) {
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.FieldsPerRecord = len(header)
+	fields, err := reader.Read()
+	if err != nil {
+		// the reader only sees this line, so its line number would be misleading
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			err = parseErr.Err
+		}
+		return TransferRequest{}, fmt.Errorf("invalid transfer request: %w", err)
+	}
+	var req TransferRequest
+	for i, field := range fields {
+		field = strings.TrimSpace(field)
+		switch header[i] {
+		case "TransferID":
+			req.TransferID = field
+		case "FromAccount":
+			req.FromAccount = field
+		case "ToAccount":
+			req.ToAccount = field
+		case "Amount":
+			if req.Amount, err = money.Parse(field); err != nil {
+				return TransferRequest{}, fmt.Errorf("invalid transfer amount (%v): %w", field, err)
+			}
+		case "Currency":
+			req.Currency = field
+		case "ToCurrency":
+			req.ToCurrency = field
+		}
+	}
+	return req, nil
+}
@@ -0,0 +1,121 @@
+package workflows_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"replay-demo/money"
+	"replay-demo/workflows"
+)
+
+// readAll reads every page of source, returning the pages read.
+func readAll(t *testing.T, source workflows.BatchSource, pageSize int) []workflows.BatchTransferPage {
+	var pages []workflows.BatchTransferPage
+	cursor := ""
+	for {
+		page, err := source.Page(context.Background(), cursor, pageSize)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(page.Requests)+len(page.Invalid), pageSize)
+		pages = append(pages, page)
+		if page.NextCursor == "" {
+			return pages
+		}
+		cursor = page.NextCursor
+	}
+}
+
+func TestFileBatchSource_JSONL(t *testing.T) {
+	pages := readAll(t, workflows.FileBatchSource{Path: "testdata/batches/01-payroll.jsonl"}, 10)
+	require.Len(t, pages, 1)
+
+	require.Equal(t, []workflows.TransferRequest{
+		{TransferID: "payroll-2023-09-alice", FromAccount: "payroll", ToAccount: "alice", Amount: 1250 * money.Unit},
+		{FromAccount: "payroll", ToAccount: "bob", Amount: 98050 * money.Cent},
+		{FromAccount: "payroll", ToAccount: "carol", Amount: 1100 * money.Unit, Currency: "EUR"},
+	}, pages[0].Requests)
+
+	require.Len(t, pages[0].Invalid, 2)
+	require.Equal(t, "01-payroll.jsonl:5", pages[0].Invalid[0].Line)
+	require.Contains(t, pages[0].Invalid[0].Error, "to account is not set")
+	require.Equal(t, "01-payroll.jsonl:6", pages[0].Invalid[1].Line)
+	require.Contains(t, pages[0].Invalid[1].Error, `unknown field "Memo"`)
+}
+
+func TestFileBatchSource_JSONLSubCentAmount(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "sub-cent.jsonl")
+	require.NoError(t, os.WriteFile(file, []byte(`{"FromAccount": "payroll", "ToAccount": "erin", "Amount": 10.005}
+{"FromAccount": "payroll", "ToAccount": "frank", "Amount": "10.005"}
+`), 0o644))
+
+	pages := readAll(t, workflows.FileBatchSource{Path: file}, 10)
+	require.Len(t, pages, 1)
+	require.Empty(t, pages[0].Requests)
+	require.Len(t, pages[0].Invalid, 2)
+	// rejected like in a CSV file, not rounded to the cent
+	require.Contains(t, pages[0].Invalid[0].Error, "invalid transfer amount (10.005)")
+	require.Contains(t, pages[0].Invalid[1].Error, `invalid transfer amount ("10.005")`)
+}
+
+func TestFileBatchSource_CSV(t *testing.T) {
+	pages := readAll(t, workflows.FileBatchSource{Path: "testdata/batches/02-vendors.csv"}, 10)
+	require.Len(t, pages, 1)
+
+	require.Equal(t, []workflows.TransferRequest{
+		{TransferID: "inv-1042", FromAccount: "operations", ToAccount: "acme-supplies", Amount: 420 * money.Unit, Currency: "USD", ToCurrency: "USD"},
+		{FromAccount: "operations", ToAccount: "Globex, Ltd.", Amount: 199999 * money.Cent, Currency: "USD", ToCurrency: "GBP"},
+	}, pages[0].Requests)
+
+	var lines, errs []string
+	for _, invalid := range pages[0].Invalid {
+		lines = append(lines, invalid.Line)
+		errs = append(errs, invalid.Error)
+	}
+	require.Equal(t, []string{"02-vendors.csv:4", "02-vendors.csv:5", "02-vendors.csv:6", "02-vendors.csv:7"}, lines)
+	require.Contains(t, errs[0], "invalid transfer amount")
+	require.Contains(t, errs[1], "wrong number of fields")
+	require.Contains(t, errs[2], "invalid transfer amount (75.123)")
+	require.Contains(t, errs[3], "unsupported currency (CHF)")
+}
+
+func TestFileBatchSource_DirectoryPages(t *testing.T) {
+	pages := readAll(t, workflows.FileBatchSource{Path: "testdata/batches"}, 4)
+
+	var cursors []string
+	var requests int
+	var invalid []string
+	for _, page := range pages {
+		cursors = append(cursors, page.NextCursor)
+		requests += len(page.Requests)
+		for _, line := range page.Invalid {
+			invalid = append(invalid, line.Line)
+		}
+	}
+	// pages continue across files, and every line is read exactly once
+	require.Equal(t, []string{"01-payroll.jsonl:6", "02-vendors.csv:5", ""}, cursors)
+	require.Equal(t, 5, requests)
+	require.Equal(t, []string{
+		"01-payroll.jsonl:5", "01-payroll.jsonl:6",
+		"02-vendors.csv:4", "02-vendors.csv:5", "02-vendors.csv:6", "02-vendors.csv:7",
+	}, invalid)
+}
+
+func TestFileBatchSource_Errors(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad-header.csv"), []byte("from,to,amount\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a batch\n"), 0o644))
+
+	_, err := workflows.FileBatchSource{Path: filepath.Join(dir, "bad-header.csv")}.Page(context.Background(), "", 10)
+	require.ErrorContains(t, err, "bad-header.csv:1: invalid header: unknown column (from)")
+
+	_, err = workflows.FileBatchSource{Path: filepath.Join(dir, "notes.txt")}.Page(context.Background(), "", 10)
+	require.ErrorContains(t, err, "unsupported batch file")
+
+	_, err = workflows.FileBatchSource{Path: filepath.Join(dir, "missing.jsonl")}.Page(context.Background(), "", 10)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = workflows.FileBatchSource{Path: "testdata/batches"}.Page(context.Background(), "03-other.jsonl:1", 10)
+	require.ErrorContains(t, err, "invalid cursor")
+}
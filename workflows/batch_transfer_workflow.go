@@ -1,36 +1,526 @@
 package workflows
 
 import (
+	"errors"
+	"fmt"
 	"time"
 
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
 )
 
-func BatchTransferWorkflow(ctx workflow.Context) error {
+const (
+	BatchTransferStatusQueryName = "batch-transfer-status"
+
+	PauseBatchUpdateName  = "pause-batch"
+	ResumeBatchUpdateName = "resume-batch"
+	AbortBatchUpdateName  = "abort-batch"
+
+	DefaultMaxConcurrentTransfers = 5
+	DefaultBatchSize              = 10
+	DefaultBatchPageSize          = 100
+	DefaultTransfersPerRun        = 1000
+
+	// MaxCarriedOverFailures caps how many failed transfers of earlier runs a batch keeps in its Items when it
+	// continues as new, so the input of the next run stays small however much of the batch failed.
+	MaxCarriedOverFailures = 100
+
+	// transferActivityTimeout bounds a Transfer activity, which waits for its transfer and so for every call the
+	// transfer makes to the bank, however much those calls are paced.
+	transferActivityTimeout = time.Hour
+	// transferHeartbeatTimeout catches a Transfer activity whose worker died long before transferActivityTimeout.
+	transferHeartbeatTimeout = 30 * time.Second
+
+	// parallelTransfersChangeID versions the switch from one transfer at a time to concurrent transfers.
+	parallelTransfersChangeID = "parallel-transfers"
+	// pagedBatchChangeID versions the switch from loading the whole batch at once to loading it page by page.
+	pagedBatchChangeID = "paged-batch"
+	// paymentAmountChangeID versions pricing each generated transfer with the GetPaymentAmount local activity.
+	paymentAmountChangeID = "payment-amount"
+	// transferIDChangeID versions running each transfer under its TransferID instead of an ID made up of its request.
+	transferIDChangeID = "transfer-id"
+)
+
+// BatchTransferMode is how the batch runs each TransferWorkflow.
+type BatchTransferMode string
+
+const (
+	// BatchTransferModeActivity starts each transfer from the Transfer activity and sends it the request as an update.
+	BatchTransferModeActivity BatchTransferMode = "activity"
+	// BatchTransferModeChildWorkflow starts each transfer as a child workflow with the request as its input, so the
+	// transfers are tied to the batch and cancelling the batch cancels them.
+	BatchTransferModeChildWorkflow BatchTransferMode = "child-workflow"
+)
+
+type BatchTransferOptions struct {
+	// MaxConcurrentTransfers caps how many transfers of the batch are in flight at once.
+	// Defaults to DefaultMaxConcurrentTransfers.
+	MaxConcurrentTransfers int
+	// Mode defaults to BatchTransferModeActivity.
+	Mode BatchTransferMode
+	// ParentClosePolicy is what happens to child transfers still running when the batch closes. Only used with
+	// BatchTransferModeChildWorkflow; defaults to terminating them.
+	ParentClosePolicy enumspb.ParentClosePolicy
+	// WaitForCancellation makes a cancelled batch wait for its child transfers to finish cancelling (or
+	// compensating) before it closes. Only used with BatchTransferModeChildWorkflow.
+	WaitForCancellation bool
+	// Source is the .jsonl or .csv file, or the directory of such files, the batch reads its transfers from. The path
+	// is read by the worker. When empty, BatchSize random transfers are generated.
+	Source string
+	// BatchSize is how many transfers are generated for the batch. Defaults to DefaultBatchSize.
+	BatchSize int
+	// PageSize is how many transfers are loaded at a time. Defaults to DefaultBatchPageSize.
+	PageSize int
+	// TransfersPerRun is how many transfers a run goes through before it continues as new, keeping the history of
+	// large batches small. Defaults to DefaultTransfersPerRun.
+	TransfersPerRun int
+
+	// Cursor, Progress and StartTime are set by the batch when it continues as new: where the next run resumes, the
+	// result so far, and when the first run started.
+	Cursor    string
+	Progress  *BatchTransferResult
+	StartTime time.Time
+}
+
+// BatchTransferPageRequest asks for the page of a batch starting at Cursor.
+type BatchTransferPageRequest struct {
+	Source string
+	// Cursor is empty for the first page.
+	Cursor    string
+	PageSize  int
+	BatchSize int
+}
+
+type BatchTransferPage struct {
+	Requests []TransferRequest
+	// Invalid lists the lines of the page that could not be read as a valid transfer request.
+	Invalid []InvalidBatchLine
+	// NextCursor is empty after the last page.
+	NextCursor string
+}
+
+type InvalidBatchLine struct {
+	// Line is the file and line number, as "name:line".
+	Line  string
+	Error string
+}
+
+// BatchTransferOutcome is how one transfer of the batch ended.
+type BatchTransferOutcome string
+
+const (
+	BatchTransferOutcomePending   BatchTransferOutcome = "pending"
+	BatchTransferOutcomeSucceeded BatchTransferOutcome = "succeeded"
+	// BatchTransferOutcomeRejected means the transfer request failed validation and no money moved.
+	BatchTransferOutcomeRejected BatchTransferOutcome = "rejected"
+	// BatchTransferOutcomeCompensated means the transfer failed and the money that moved was put back.
+	BatchTransferOutcomeCompensated BatchTransferOutcome = "compensated"
+	// BatchTransferOutcomeErrored means the transfer failed otherwise, including when its compensation failed.
+	BatchTransferOutcomeErrored BatchTransferOutcome = "errored"
+	// BatchTransferOutcomeSkipped means the batch was aborted before the transfer started.
+	BatchTransferOutcomeSkipped BatchTransferOutcome = "skipped"
+	// BatchTransferOutcomeDuplicate means a transfer with the same TransferID had already been started, by this batch
+	// or another, so the transfer was not run again.
+	BatchTransferOutcomeDuplicate BatchTransferOutcome = "duplicate"
+)
+
+// BatchTransferItem is the result of one transfer of the batch.
+type BatchTransferItem struct {
+	Request    TransferRequest
+	WorkflowID string
+	Outcome    BatchTransferOutcome
+	Error      string
+}
+
+// BatchTransferResult is returned by the BatchTransferStatusQueryName query, and by BatchTransferWorkflow once it is
+// done. Items are in request order. The counts cover the whole batch, but once the batch has continued as new, Items
+// only keep the first MaxCarriedOverFailures transfers of earlier runs that did not succeed.
+type BatchTransferResult struct {
+	Items []BatchTransferItem
+	// OmittedFailures counts the transfers of earlier runs that did not succeed but were left out of Items.
+	OmittedFailures int
+	Succeeded       int
+	Rejected        int
+	Compensated     int
+	Errored         int
+	Skipped         int
+	Duplicate       int
+	// Paused holds back transfers that have not started yet, until the batch is resumed.
+	Paused bool
+	// Aborted means the batch stopped early. Transfers already loaded but not started are skipped, and the rest of the
+	// batch is not read.
+	Aborted bool
+}
+
+func (r *BatchTransferResult) complete(i int, outcome BatchTransferOutcome, err error) {
+	r.Items[i].Outcome = outcome
+	if err != nil {
+		r.Items[i].Error = err.Error()
+	}
+	switch outcome {
+	case BatchTransferOutcomeSucceeded:
+		r.Succeeded++
+	case BatchTransferOutcomeRejected:
+		r.Rejected++
+	case BatchTransferOutcomeCompensated:
+		r.Compensated++
+	case BatchTransferOutcomeSkipped:
+		r.Skipped++
+	case BatchTransferOutcomeDuplicate:
+		r.Duplicate++
+	default:
+		r.Errored++
+	}
+}
+
+// done is how many transfers and invalid lines of the batch have an outcome, across runs.
+func (r *BatchTransferResult) done() int {
+	return r.Succeeded + r.Rejected + r.Compensated + r.Errored + r.Skipped + r.Duplicate
+}
+
+// carryOver is the result handed to the next run: the counts, and the failed transfers up to MaxCarriedOverFailures.
+func (r *BatchTransferResult) carryOver() *BatchTransferResult {
+	next := *r
+	next.Items = nil
+	for _, item := range r.Items {
+		if item.Outcome == BatchTransferOutcomeSucceeded {
+			continue
+		}
+		if len(next.Items) == MaxCarriedOverFailures {
+			next.OmittedFailures++
+			continue
+		}
+		next.Items = append(next.Items, item)
+	}
+	return &next
+}
+
+// reject appends the invalid lines of a page as rejected items.
+func (r *BatchTransferResult) reject(lines []InvalidBatchLine) {
+	for _, line := range lines {
+		r.Items = append(r.Items, BatchTransferItem{})
+		r.complete(len(r.Items)-1, BatchTransferOutcomeRejected, fmt.Errorf("%s: %s", line.Line, line.Error))
+	}
+}
+
+// add appends the transfers of reqs as pending items, and returns the index of the first one. With transferIDs, each
+// transfer runs under its TransferID, and requests without one are numbered after the transfers done so far.
+func (r *BatchTransferResult) add(batchID string, reqs []TransferRequest, transferIDs bool) int {
+	first := len(r.Items)
+	seq := r.done()
+	for _, req := range reqs {
+		workflowID := batchTransferWorkflowID(batchID, req)
+		if transferIDs {
+			seq++
+			if req.TransferID == "" {
+				req.TransferID = fmt.Sprintf("%s-%d", batchID, seq)
+			}
+			workflowID = req.TransferID
+		}
+		r.Items = append(r.Items, BatchTransferItem{
+			Request:    req,
+			WorkflowID: workflowID,
+			Outcome:    BatchTransferOutcomePending,
+		})
+	}
+	return first
+}
+
+func BatchTransferWorkflow(ctx workflow.Context, options BatchTransferOptions) (_ BatchTransferResult, err error) {
+	var result BatchTransferResult
+	if options.Progress != nil {
+		result = *options.Progress
+	}
+	if options.StartTime.IsZero() {
+		options.StartTime = workflow.GetInfo(ctx).WorkflowStartTime
+	}
+	defer func() {
+		if !workflow.IsContinueAsNewError(err) {
+			workflow.GetMetricsHandler(ctx).Timer(BatchTransferDurationMetricName).Record(workflow.Now(ctx).Sub(options.StartTime))
+		}
+	}()
+	if err := workflow.SetQueryHandler(ctx, BatchTransferStatusQueryName, func() (BatchTransferResult, error) {
+		return result, nil
+	}); err != nil {
+		return result, err
+	}
+
+	// Operators can pause, resume and abort the batch. Transfers already in flight are never interrupted.
+	if err := workflow.SetUpdateHandlerWithOptions(
+		ctx,
+		PauseBatchUpdateName,
+		func(ctx workflow.Context) error {
+			result.Paused = true
+			return nil
+		},
+		workflow.UpdateHandlerOptions{Validator: func(ctx workflow.Context) error {
+			if result.Aborted {
+				workflow.GetLogger(ctx).Debug("Rejecting pause request", "aborted", result.Aborted)
+				return countRejection(ctx, PauseBatchUpdateName, rejectFor("aborted", fmt.Errorf("batch already aborted")))
+			}
+			if result.Paused {
+				workflow.GetLogger(ctx).Debug("Rejecting pause request", "paused", result.Paused)
+				return countRejection(ctx, PauseBatchUpdateName, rejectFor("paused", fmt.Errorf("batch already paused")))
+			}
+			return nil
+		}},
+	); err != nil {
+		return result, err
+	}
+	if err := workflow.SetUpdateHandlerWithOptions(
+		ctx,
+		ResumeBatchUpdateName,
+		func(ctx workflow.Context) error {
+			result.Paused = false
+			return nil
+		},
+		workflow.UpdateHandlerOptions{Validator: func(ctx workflow.Context) error {
+			if !result.Paused || result.Aborted {
+				workflow.GetLogger(ctx).Debug("Rejecting resume request", "aborted", result.Aborted, "paused", result.Paused)
+				return countRejection(ctx, ResumeBatchUpdateName, rejectFor("not-paused", fmt.Errorf("batch is not paused")))
+			}
+			return nil
+		}},
+	); err != nil {
+		return result, err
+	}
+	if err := workflow.SetUpdateHandlerWithOptions(
+		ctx,
+		AbortBatchUpdateName,
+		func(ctx workflow.Context) error {
+			result.Aborted = true
+			return nil
+		},
+		workflow.UpdateHandlerOptions{Validator: func(ctx workflow.Context) error {
+			if result.Aborted {
+				workflow.GetLogger(ctx).Debug("Rejecting abort request", "aborted", result.Aborted)
+				return countRejection(ctx, AbortBatchUpdateName, rejectFor("aborted", fmt.Errorf("batch already aborted")))
+			}
+			return nil
+		}},
+	); err != nil {
+		return result, err
+	}
+
+	if options.MaxConcurrentTransfers <= 0 {
+		options.MaxConcurrentTransfers = DefaultMaxConcurrentTransfers
+	}
+	if options.Mode == "" {
+		options.Mode = BatchTransferModeActivity
+	}
+	if options.Mode != BatchTransferModeActivity && options.Mode != BatchTransferModeChildWorkflow {
+		return result, fmt.Errorf("unknown batch transfer mode (%v)", options.Mode)
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = DefaultBatchSize
+	}
+	if options.PageSize <= 0 {
+		options.PageSize = DefaultBatchPageSize
+	}
+	if options.TransfersPerRun <= 0 {
+		options.TransfersPerRun = DefaultTransfersPerRun
+	}
 	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
 		StartToCloseTimeout: time.Second * 10,
 	})
 	ctx = workflow.WithLocalActivityOptions(ctx, workflow.LocalActivityOptions{StartToCloseTimeout: time.Second})
+	batchID := workflow.GetInfo(ctx).WorkflowExecution.ID
 
-	var batchTransfers []TransferRequest
 	var a *TransferActivity
-	err := workflow.ExecuteActivity(ctx, a.GetBatchTransferRequest).Get(ctx, &batchTransfers)
-	if err != nil {
-		return err
+	if workflow.GetVersion(ctx, pagedBatchChangeID, workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+		var batchTransfers []TransferRequest
+		err := workflow.ExecuteActivity(ctx, a.GetBatchTransferRequest).Get(ctx, &batchTransfers)
+		if err != nil {
+			return result, err
+		}
+		result.add(batchID, batchTransfers, false)
+		if workflow.GetVersion(ctx, parallelTransfersChangeID, workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+			return result, sequentialTransfers(ctx, &result)
+		}
+		return result, runTransfers(ctx, options, &result, 0)
+	}
+
+	// Load and run the batch a page at a time, continuing as new once this run went through TransfersPerRun.
+	transferIDs := workflow.GetVersion(ctx, transferIDChangeID, workflow.DefaultVersion, 1) == 1
+	transfers := 0
+	for {
+		if err := awaitUnpaused(ctx, &result); err != nil {
+			return result, err
+		}
+		if result.Aborted {
+			return result, nil
+		}
+		var page BatchTransferPage
+		err := workflow.ExecuteActivity(ctx, a.GetBatchTransferPage, BatchTransferPageRequest{
+			Source:    options.Source,
+			Cursor:    options.Cursor,
+			PageSize:  options.PageSize,
+			BatchSize: options.BatchSize,
+		}).Get(ctx, &page)
+		if err != nil {
+			return result, err
+		}
+		// invalid lines of the page are reported after its transfers
+		if err := runTransfers(ctx, options, &result, result.add(batchID, page.Requests, transferIDs)); err != nil {
+			return result, err
+		}
+		result.reject(page.Invalid)
+		transfers += len(page.Requests) + len(page.Invalid)
+
+		if page.NextCursor == "" || result.Aborted {
+			return result, nil
+		}
+		options.Cursor = page.NextCursor
+		if transfers >= options.TransfersPerRun {
+			options.Progress = result.carryOver()
+			return BatchTransferResult{}, workflow.NewContinueAsNewError(ctx, BatchTransferWorkflow, options)
+		}
+	}
+}
+
+// runTransfers runs the transfers of result.Items from first on as futures, keeping at most MaxConcurrentTransfers in
+// flight. A failed transfer doesn't stop the others; every transfer gets an outcome. Pausing and aborting the batch
+// take effect before the next transfer starts.
+func runTransfers(ctx workflow.Context, options BatchTransferOptions, result *BatchTransferResult, first int) error {
+	var a *TransferActivity
+	// Generated transfers all have the same amount until they are priced. Batches from a source have real amounts.
+	priced := options.Source == "" && workflow.GetVersion(ctx, paymentAmountChangeID, workflow.DefaultVersion, 1) == 1
+	selector := workflow.NewSelector(ctx)
+	inFlight := 0
+	for i := first; i < len(result.Items); i++ {
+		for inFlight >= options.MaxConcurrentTransfers {
+			selector.Select(ctx)
+			inFlight--
+		}
+		if err := awaitUnpaused(ctx, result); err != nil {
+			return err
+		}
+		if result.Aborted {
+			for ; i < len(result.Items); i++ {
+				result.complete(i, BatchTransferOutcomeSkipped, nil)
+			}
+			break
+		}
+
+		i, req := i, result.Items[i].Request
+		if priced {
+			err := workflow.ExecuteLocalActivity(ctx, a.GetPaymentAmount, req).Get(ctx, &req.Amount)
+			if err != nil {
+				result.complete(i, BatchTransferOutcomeErrored, err)
+				continue
+			}
+			result.Items[i].Request = req
+			if req.TransferID == "" {
+				result.Items[i].WorkflowID = batchTransferWorkflowID(workflow.GetInfo(ctx).WorkflowExecution.ID, req)
+			}
+		}
+		inFlight++
+		if options.Mode == BatchTransferModeChildWorkflow {
+			childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+				WorkflowID:          result.Items[i].WorkflowID,
+				ParentClosePolicy:   options.ParentClosePolicy,
+				WaitForCancellation: options.WaitForCancellation,
+				// a transfer ID is used once, even after its transfer closed
+				WorkflowIDReusePolicy: enumspb.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
+			})
+			future := workflow.ExecuteChildWorkflow(childCtx, TransferWorkflow, TransferWorkflowOptions{Request: &req})
+			selector.AddFuture(future, func(f workflow.Future) {
+				var status TransferStatus
+				if err := f.Get(ctx, &status); err != nil {
+					if temporal.IsWorkflowExecutionAlreadyStartedError(err) {
+						result.complete(i, BatchTransferOutcomeDuplicate, duplicateTransferError(result.Items[i].WorkflowID))
+						return
+					}
+					result.complete(i, BatchTransferOutcomeErrored, err)
+					return
+				}
+				var err error
+				if status.Stage != TransferStageCompleted {
+					err = errors.New(status.LastError)
+				}
+				result.complete(i, transferOutcome(status.Stage), err)
+			})
+			continue
+		}
+		selector.AddFuture(workflow.ExecuteActivity(withTransferActivityOptions(ctx), a.Transfer, req), func(f workflow.Future) {
+			err := f.Get(ctx, nil)
+			result.complete(i, transferErrorOutcome(err), err)
+		})
 	}
+	for ; inFlight > 0; inFlight-- {
+		selector.Select(ctx)
+	}
+	return nil
+}
 
-	for _, req := range batchTransfers {
-		//var amount float64
-		//err := workflow.ExecuteLocalActivity(ctx, a.GetPaymentAmount, req).Get(ctx, &amount)
-		//if err != nil {
-		//	return err
-		//}
-		//req.Amount = amount
+// awaitUnpaused blocks while the batch is paused, unless it gets aborted.
+func awaitUnpaused(ctx workflow.Context, result *BatchTransferResult) error {
+	return workflow.Await(ctx, func() bool { return !result.Paused || result.Aborted })
+}
 
-		err = workflow.ExecuteActivity(ctx, a.Transfer, req).Get(ctx, nil)
+// sequentialTransfers runs one transfer at a time, failing the batch at the first failed transfer. Batches started
+// before transfers ran concurrently replay through it.
+func sequentialTransfers(ctx workflow.Context, result *BatchTransferResult) error {
+	var a *TransferActivity
+	for i, item := range result.Items {
+		err := workflow.ExecuteActivity(withTransferActivityOptions(ctx), a.Transfer, item.Request).Get(ctx, nil)
+		result.complete(i, transferErrorOutcome(err), err)
 		if err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+// withTransferActivityOptions gives the Transfer activity as long as its transfer takes, which pacing calls to the bank
+// can stretch well past the timeout of the other activities. The activity heartbeats while it waits instead.
+func withTransferActivityOptions(ctx workflow.Context) workflow.Context {
+	options := workflow.GetActivityOptions(ctx)
+	options.StartToCloseTimeout = transferActivityTimeout
+	options.HeartbeatTimeout = transferHeartbeatTimeout
+	return workflow.WithActivityOptions(ctx, options)
+}
+
+// transferOutcome is the outcome of a transfer that ended in stage.
+func transferOutcome(stage TransferStage) BatchTransferOutcome {
+	switch stage {
+	case TransferStageCompleted:
+		return BatchTransferOutcomeSucceeded
+	case TransferStageRejected:
+		return BatchTransferOutcomeRejected
+	case TransferStageCompensated:
+		return BatchTransferOutcomeCompensated
+	}
+	return BatchTransferOutcomeErrored
+}
+
+// transferErrorOutcome is the outcome of a Transfer activity that returned err.
+func transferErrorOutcome(err error) BatchTransferOutcome {
+	if err == nil {
+		return BatchTransferOutcomeSucceeded
+	}
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) {
+		switch appErr.Type() {
+		case transferRejectedErrorType:
+			return BatchTransferOutcomeRejected
+		case transferCompensatedErrorType:
+			return BatchTransferOutcomeCompensated
+		case transferDuplicateErrorType:
+			return BatchTransferOutcomeDuplicate
+		}
+	}
+	return BatchTransferOutcomeErrored
+}
+
+// batchTransferWorkflowID is the ID of the TransferWorkflow a batch runs for a req without TransferID. Only batches
+// started before transfers had IDs still use it.
+func batchTransferWorkflowID(batchID string, req TransferRequest) string {
+	return fmt.Sprintf("%s_%s_%s_$%v", batchID, req.FromAccount, req.ToAccount, req.Amount)
+}
+
+// duplicateTransferError reports a transfer that was not run because its ID had been used already.
+func duplicateTransferError(transferID string) error {
+	return temporal.NewNonRetryableApplicationError(fmt.Sprintf("duplicate transfer (%v)", transferID), transferDuplicateErrorType, nil)
+}
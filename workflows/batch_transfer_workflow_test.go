@@ -0,0 +1,504 @@
+package workflows_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+	"replay-demo/money"
+	"replay-demo/ratelimit"
+	"replay-demo/workflows"
+)
+
+func makeBatch(n int) []workflows.TransferRequest {
+	batch := make([]workflows.TransferRequest, n)
+	for i := range batch {
+		batch[i] = workflows.TransferRequest{
+			TransferID:  fmt.Sprintf("transfer-%d", i),
+			FromAccount: fmt.Sprintf("from-%d", i),
+			ToAccount:   fmt.Sprintf("to-%d", i),
+			Amount:      10 * money.Unit,
+		}
+	}
+	return batch
+}
+
+// keepPaymentAmounts stops generated transfers from being priced, so they keep the amounts of the mocked batch.
+func keepPaymentAmounts(env *testsuite.TestWorkflowEnvironment, a *workflows.TransferActivity) {
+	env.OnActivity(a.GetPaymentAmount, mock.Anything).Return(
+		func(req workflows.TransferRequest) (money.Amount, error) {
+			return req.Amount, nil
+		})
+}
+
+func TestBatchTransferWorkflow_ConcurrencyCap(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.BatchTransferWorkflow)
+	a := &workflows.TransferActivity{}
+	env.RegisterActivity(a)
+
+	batch := makeBatch(7)
+	env.OnActivity(a.GetBatchTransferPage, mock.Anything, mock.Anything).Return(workflows.BatchTransferPage{Requests: batch}, nil)
+	keepPaymentAmounts(env, a)
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	env.OnActivity(a.Transfer, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, req workflows.TransferRequest) (string, error) {
+			mu.Lock()
+			inFlight++
+			maxInFlight = max(maxInFlight, inFlight)
+			mu.Unlock()
+
+			time.Sleep(50 * time.Millisecond)
+
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+			return "", nil
+		})
+
+	env.ExecuteWorkflow(workflows.BatchTransferWorkflow, workflows.BatchTransferOptions{MaxConcurrentTransfers: 3})
+
+	require.NoError(t, env.GetWorkflowError())
+	require.Equal(t, 3, maxInFlight)
+
+	var result workflows.BatchTransferResult
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, len(batch), result.Succeeded)
+	require.Len(t, result.Items, len(batch))
+	for i, item := range result.Items {
+		require.Equal(t, batch[i], item.Request)
+		require.Equal(t, workflows.BatchTransferOutcomeSucceeded, item.Outcome)
+		require.Empty(t, item.Error)
+	}
+}
+
+func TestBatchTransferWorkflow_TightRateLimit(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.BatchTransferWorkflow)
+	// one call to the bank every 100ms, for every account
+	a := &workflows.TransferActivity{RateLimiter: ratelimit.New([]ratelimit.Rule{{PerSecond: 10, Burst: 1}})}
+	env.RegisterActivity(a)
+
+	batch := makeBatch(5)
+	env.OnActivity(a.GetBatchTransferPage, mock.Anything, mock.Anything).Return(workflows.BatchTransferPage{Requests: batch}, nil)
+	keepPaymentAmounts(env, a)
+
+	var mu sync.Mutex
+	var infos []activity.Info
+	env.OnActivity(a.Transfer, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, req workflows.TransferRequest) (string, error) {
+			// the transfer waited for calls the bank once for each side, at the pace of the rate limit
+			for _, accountID := range []string{req.FromAccount, req.ToAccount} {
+				if err := a.RateLimiter.Wait(ctx, accountID); err != nil {
+					return "", err
+				}
+				activity.RecordHeartbeat(ctx)
+			}
+			mu.Lock()
+			infos = append(infos, activity.GetInfo(ctx))
+			mu.Unlock()
+			return req.TransferID, nil
+		})
+
+	env.ExecuteWorkflow(workflows.BatchTransferWorkflow, workflows.BatchTransferOptions{MaxConcurrentTransfers: len(batch)})
+
+	var result workflows.BatchTransferResult
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, len(batch), result.Succeeded)
+	require.Len(t, infos, len(batch))
+	// even the last transfer of a run of the batch at this pace gets through before its activity times out, and a
+	// Transfer whose worker died is caught by its heartbeat timeout instead
+	fullRun := time.Duration(2*workflows.DefaultTransfersPerRun/10) * time.Second
+	for _, info := range infos {
+		require.GreaterOrEqual(t, info.Deadline.Sub(info.StartedTime), fullRun)
+		require.Positive(t, info.HeartbeatTimeout)
+	}
+}
+
+func TestBatchTransferWorkflow_PartialFailure(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.BatchTransferWorkflow)
+	a := &workflows.TransferActivity{}
+	env.RegisterActivity(a)
+
+	batch := makeBatch(5)
+	env.OnActivity(a.GetBatchTransferPage, mock.Anything, mock.Anything).Return(workflows.BatchTransferPage{Requests: batch}, nil)
+	keepPaymentAmounts(env, a)
+	env.OnActivity(a.Transfer, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, req workflows.TransferRequest) (string, error) {
+			switch req.FromAccount {
+			case "from-1":
+				// fails after the transfers behind it complete
+				time.Sleep(50 * time.Millisecond)
+				return "", temporal.NewNonRetryableApplicationError("invalid transfer amount", "transfer-rejected", nil)
+			case "from-2":
+				return "", temporal.NewNonRetryableApplicationError("account is frozen", "transfer-compensated", nil)
+			case "from-3":
+				return "", temporal.NewNonRetryableApplicationError("bank unavailable", "", nil)
+			case "from-4":
+				return "", temporal.NewNonRetryableApplicationError("duplicate transfer (transfer-4)", "transfer-duplicate", nil)
+			}
+			return "", nil
+		})
+
+	env.ExecuteWorkflow(workflows.BatchTransferWorkflow, workflows.BatchTransferOptions{})
+
+	var result workflows.BatchTransferResult
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, 1, result.Succeeded)
+	require.Equal(t, 1, result.Rejected)
+	require.Equal(t, 1, result.Compensated)
+	require.Equal(t, 1, result.Errored)
+	require.Equal(t, 1, result.Duplicate)
+
+	// items are in request order, not completion order
+	var outcomes []workflows.BatchTransferOutcome
+	for _, item := range result.Items {
+		outcomes = append(outcomes, item.Outcome)
+	}
+	require.Equal(t, []workflows.BatchTransferOutcome{
+		workflows.BatchTransferOutcomeSucceeded,
+		workflows.BatchTransferOutcomeRejected,
+		workflows.BatchTransferOutcomeCompensated,
+		workflows.BatchTransferOutcomeErrored,
+		workflows.BatchTransferOutcomeDuplicate,
+	}, outcomes)
+	require.Contains(t, result.Items[1].Error, "invalid transfer amount")
+	require.Contains(t, result.Items[3].Error, "bank unavailable")
+}
+
+func TestBatchTransferWorkflow_StatusQuery(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.BatchTransferWorkflow)
+	a := &workflows.TransferActivity{}
+	env.RegisterActivity(a)
+
+	batch := makeBatch(3)
+	env.OnActivity(a.GetBatchTransferPage, mock.Anything, mock.Anything).Return(workflows.BatchTransferPage{Requests: batch}, nil)
+	keepPaymentAmounts(env, a)
+	// the last transfer finishes a minute after the others
+	env.OnActivity(a.Transfer, mock.Anything, batch[0]).Return("", nil)
+	env.OnActivity(a.Transfer, mock.Anything, batch[1]).Return("", nil)
+	env.OnActivity(a.Transfer, mock.Anything, batch[2]).After(time.Minute).Return("", nil)
+
+	var running workflows.BatchTransferResult
+	env.RegisterDelayedCallback(func() {
+		value, err := env.QueryWorkflow(workflows.BatchTransferStatusQueryName)
+		require.NoError(t, err)
+		require.NoError(t, value.Get(&running))
+	}, 30*time.Second)
+
+	env.ExecuteWorkflow(workflows.BatchTransferWorkflow, workflows.BatchTransferOptions{})
+	require.NoError(t, env.GetWorkflowError())
+
+	require.Equal(t, 2, running.Succeeded)
+	require.Len(t, running.Items, 3)
+	require.Equal(t, workflows.BatchTransferOutcomePending, running.Items[2].Outcome)
+}
+
+func TestBatchTransferWorkflow_ChildWorkflows(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.BatchTransferWorkflow)
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	bank := workflows.NewInMemoryBankGateway(100 * money.Unit)
+	a := &workflows.TransferActivity{Bank: bank}
+	env.RegisterActivity(a)
+
+	batch := []workflows.TransferRequest{
+		{FromAccount: "alice", ToAccount: "bob", Amount: 10 * money.Unit},
+		{FromAccount: "alice", ToAccount: "carol", Amount: 0},
+		{FromAccount: "piggy-bank", ToAccount: "bob", Amount: 10 * money.Unit},
+	}
+	env.OnActivity(a.GetBatchTransferPage, mock.Anything, mock.Anything).Return(workflows.BatchTransferPage{Requests: batch}, nil)
+	keepPaymentAmounts(env, a)
+
+	env.ExecuteWorkflow(workflows.BatchTransferWorkflow, workflows.BatchTransferOptions{
+		Mode: workflows.BatchTransferModeChildWorkflow,
+	})
+
+	var result workflows.BatchTransferResult
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, workflows.BatchTransferOutcomeSucceeded, result.Items[0].Outcome)
+	// transfers without an ID are numbered within the batch
+	require.Equal(t, "default-test-workflow-id-1", result.Items[0].Request.TransferID)
+	require.Equal(t, "default-test-workflow-id-1", result.Items[0].WorkflowID)
+	require.Equal(t, workflows.BatchTransferOutcomeRejected, result.Items[1].Outcome)
+	require.Contains(t, result.Items[1].Error, "invalid transfer amount")
+	// no money moved, so there was nothing to compensate
+	require.Equal(t, workflows.BatchTransferOutcomeErrored, result.Items[2].Outcome)
+	require.Contains(t, result.Items[2].Error, "account is frozen")
+
+	// the valid transfer went through even though others in the batch failed
+	requireBalance(t, bank, "alice", "USD", 90*money.Unit)
+	requireBalance(t, bank, "bob", "USD", 110*money.Unit)
+	requireBalance(t, bank, "piggy-bank", "USD", 100*money.Unit)
+}
+
+func TestBatchTransferWorkflow_DuplicateTransferIDs(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.BatchTransferWorkflow)
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	bank := workflows.NewInMemoryBankGateway(100 * money.Unit)
+	a := &workflows.TransferActivity{Bank: bank}
+	env.RegisterActivity(a)
+
+	batch := []workflows.TransferRequest{
+		{TransferID: "rent-2023-09", FromAccount: "alice", ToAccount: "bob", Amount: 10 * money.Unit},
+		{TransferID: "rent-2023-09", FromAccount: "alice", ToAccount: "bob", Amount: 10 * money.Unit},
+		{FromAccount: "alice", ToAccount: "bob", Amount: 10 * money.Unit},
+		{FromAccount: "alice", ToAccount: "bob", Amount: 10 * money.Unit},
+	}
+	env.OnActivity(a.GetBatchTransferPage, mock.Anything, mock.Anything).Return(workflows.BatchTransferPage{Requests: batch}, nil)
+	keepPaymentAmounts(env, a)
+
+	env.ExecuteWorkflow(workflows.BatchTransferWorkflow, workflows.BatchTransferOptions{
+		Mode:                   workflows.BatchTransferModeChildWorkflow,
+		MaxConcurrentTransfers: 1,
+	})
+
+	var result workflows.BatchTransferResult
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, 3, result.Succeeded)
+	require.Equal(t, 1, result.Duplicate)
+	require.Equal(t, workflows.BatchTransferOutcomeDuplicate, result.Items[1].Outcome)
+	require.Contains(t, result.Items[1].Error, "duplicate transfer (rent-2023-09)")
+	// identical requests without an ID are different transfers
+	require.Equal(t, workflows.BatchTransferOutcomeSucceeded, result.Items[3].Outcome)
+	requireBalance(t, bank, "alice", "USD", 70*money.Unit)
+}
+
+func TestBatchTransferWorkflow_UnknownMode(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.BatchTransferWorkflow)
+
+	env.ExecuteWorkflow(workflows.BatchTransferWorkflow, workflows.BatchTransferOptions{Mode: "carrier-pigeon"})
+
+	err := env.GetWorkflowError()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unknown batch transfer mode")
+}
+
+func TestBatchTransferWorkflow_ContinueAsNew(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	a := &workflows.TransferActivity{}
+	var mu sync.Mutex
+	calls := 0
+	transfer := func(ctx context.Context, req workflows.TransferRequest) (string, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 2 {
+			return "", temporal.NewNonRetryableApplicationError("invalid transfer amount", "transfer-rejected", nil)
+		}
+		return "", nil
+	}
+
+	// 7 transfers in pages of 3: the first run goes through 2 pages before continuing as new
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.BatchTransferWorkflow)
+	env.RegisterActivity(a)
+	env.OnActivity(a.Transfer, mock.Anything, mock.Anything).Return(transfer)
+	env.ExecuteWorkflow(workflows.BatchTransferWorkflow, workflows.BatchTransferOptions{
+		BatchSize:       7,
+		PageSize:        3,
+		TransfersPerRun: 5,
+	})
+
+	var continueAsNew *workflow.ContinueAsNewError
+	require.ErrorAs(t, env.GetWorkflowError(), &continueAsNew)
+	var next workflows.BatchTransferOptions
+	require.NoError(t, converter.GetDefaultDataConverter().FromPayloads(continueAsNew.Input, &next))
+	require.Equal(t, "6", next.Cursor)
+	require.Equal(t, 5, next.Progress.Succeeded)
+	require.Equal(t, 1, next.Progress.Rejected)
+	// succeeded transfers are not carried over
+	require.Len(t, next.Progress.Items, 1)
+	require.Equal(t, workflows.BatchTransferOutcomeRejected, next.Progress.Items[0].Outcome)
+
+	env = suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.BatchTransferWorkflow)
+	env.RegisterActivity(a)
+	env.OnActivity(a.Transfer, mock.Anything, mock.Anything).Return(transfer)
+	env.ExecuteWorkflow(workflows.BatchTransferWorkflow, next)
+
+	var result workflows.BatchTransferResult
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, 6, result.Succeeded)
+	require.Equal(t, 1, result.Rejected)
+	require.Len(t, result.Items, 2)
+	require.Equal(t, workflows.BatchTransferOutcomeRejected, result.Items[0].Outcome)
+	require.Equal(t, workflows.BatchTransferOutcomeSucceeded, result.Items[1].Outcome)
+	// transfer IDs keep counting across runs
+	require.Equal(t, "default-test-workflow-id-7", result.Items[1].Request.TransferID)
+}
+
+func TestBatchTransferWorkflow_ContinueAsNewCapsCarriedFailures(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.BatchTransferWorkflow)
+	a := &workflows.TransferActivity{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.Transfer, mock.Anything, mock.Anything).
+		Return("", temporal.NewNonRetryableApplicationError("account is frozen", "transfer-failed", nil))
+
+	failed := workflows.MaxCarriedOverFailures + 20
+	env.ExecuteWorkflow(workflows.BatchTransferWorkflow, workflows.BatchTransferOptions{
+		BatchSize:       failed + 1,
+		PageSize:        failed,
+		TransfersPerRun: failed,
+	})
+
+	var continueAsNew *workflow.ContinueAsNewError
+	require.ErrorAs(t, env.GetWorkflowError(), &continueAsNew)
+	var next workflows.BatchTransferOptions
+	require.NoError(t, converter.GetDefaultDataConverter().FromPayloads(continueAsNew.Input, &next))
+	require.Equal(t, failed, next.Progress.Errored)
+	require.Len(t, next.Progress.Items, workflows.MaxCarriedOverFailures)
+	require.Equal(t, 20, next.Progress.OmittedFailures)
+}
+
+func TestBatchTransferWorkflow_FileSource(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.BatchTransferWorkflow)
+	a := &workflows.TransferActivity{}
+	env.RegisterActivity(a)
+	env.OnActivity(a.Transfer, mock.Anything, mock.Anything).Return("", nil)
+
+	env.ExecuteWorkflow(workflows.BatchTransferWorkflow, workflows.BatchTransferOptions{
+		Source:   "testdata/batches",
+		PageSize: 4,
+	})
+
+	var result workflows.BatchTransferResult
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, 5, result.Succeeded)
+	require.Equal(t, 6, result.Rejected)
+	require.Len(t, result.Items, 11)
+	// invalid lines are reported after the transfers of their page
+	require.Equal(t, "alice", result.Items[0].Request.ToAccount)
+	require.Equal(t, workflows.BatchTransferOutcomeRejected, result.Items[3].Outcome)
+	require.Contains(t, result.Items[3].Error, "01-payroll.jsonl:5: to account is not set")
+}
+
+func TestBatchTransferWorkflow_PaymentAmount(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.BatchTransferWorkflow)
+	a := &workflows.TransferActivity{}
+	env.RegisterActivity(a)
+
+	batch := makeBatch(3)
+	env.OnActivity(a.GetBatchTransferPage, mock.Anything, mock.Anything).Return(workflows.BatchTransferPage{Requests: batch}, nil)
+	env.OnActivity(a.GetPaymentAmount, mock.Anything).Return(42*money.Unit, nil)
+	var transferred []money.Amount
+	env.OnActivity(a.Transfer, mock.Anything, mock.Anything).Return(
+		func(ctx context.Context, req workflows.TransferRequest) (string, error) {
+			transferred = append(transferred, req.Amount)
+			return "", nil
+		})
+
+	env.ExecuteWorkflow(workflows.BatchTransferWorkflow, workflows.BatchTransferOptions{MaxConcurrentTransfers: 1})
+
+	var result workflows.BatchTransferResult
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, []money.Amount{42 * money.Unit, 42 * money.Unit, 42 * money.Unit}, transferred)
+	require.Equal(t, 42*money.Unit, result.Items[0].Request.Amount)
+	require.Equal(t, "transfer-0", result.Items[0].WorkflowID)
+}
+
+func TestBatchTransferWorkflow_PauseResume(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.BatchTransferWorkflow)
+	a := &workflows.TransferActivity{}
+	env.RegisterActivity(a)
+
+	batch := makeBatch(3)
+	env.OnActivity(a.GetBatchTransferPage, mock.Anything, mock.Anything).Return(workflows.BatchTransferPage{Requests: batch}, nil)
+	keepPaymentAmounts(env, a)
+	env.OnActivity(a.Transfer, mock.Anything, mock.Anything).After(time.Minute).Return("", nil)
+
+	var pause, resumeTooEarly, resume updateCallback
+	var paused workflows.BatchTransferResult
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.ResumeBatchUpdateName, "resume-too-early", &resumeTooEarly)
+		env.UpdateWorkflow(workflows.PauseBatchUpdateName, "pause", &pause)
+	}, 30*time.Second)
+	env.RegisterDelayedCallback(func() {
+		value, err := env.QueryWorkflow(workflows.BatchTransferStatusQueryName)
+		require.NoError(t, err)
+		require.NoError(t, value.Get(&paused))
+	}, time.Hour)
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.ResumeBatchUpdateName, "resume", &resume)
+	}, 2*time.Hour)
+
+	env.ExecuteWorkflow(workflows.BatchTransferWorkflow, workflows.BatchTransferOptions{MaxConcurrentTransfers: 1})
+
+	require.ErrorContains(t, resumeTooEarly.rejectedErr, "batch is not paused")
+	require.True(t, pause.accepted)
+	require.True(t, resume.accepted)
+
+	// the transfer in flight when the batch was paused completed, the next one waited
+	require.True(t, paused.Paused)
+	require.Equal(t, 1, paused.Succeeded)
+	require.Equal(t, workflows.BatchTransferOutcomePending, paused.Items[1].Outcome)
+
+	var result workflows.BatchTransferResult
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.False(t, result.Paused)
+	require.Equal(t, 3, result.Succeeded)
+}
+
+func TestBatchTransferWorkflow_Abort(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.BatchTransferWorkflow)
+	a := &workflows.TransferActivity{}
+	env.RegisterActivity(a)
+
+	batch := makeBatch(5)
+	env.OnActivity(a.GetBatchTransferPage, mock.Anything, mock.Anything).Return(
+		workflows.BatchTransferPage{Requests: batch, NextCursor: "5"}, nil)
+	keepPaymentAmounts(env, a)
+	env.OnActivity(a.Transfer, mock.Anything, mock.Anything).After(time.Minute).Return("", nil)
+
+	var abort, abortAgain updateCallback
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.AbortBatchUpdateName, "abort", &abort)
+		env.UpdateWorkflow(workflows.AbortBatchUpdateName, "abort-again", &abortAgain)
+	}, 30*time.Second)
+
+	env.ExecuteWorkflow(workflows.BatchTransferWorkflow, workflows.BatchTransferOptions{MaxConcurrentTransfers: 2})
+
+	require.True(t, abort.accepted)
+	require.ErrorContains(t, abortAgain.rejectedErr, "batch already aborted")
+
+	// the transfers in flight completed, the rest were skipped and the next page was never read
+	var result workflows.BatchTransferResult
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.True(t, result.Aborted)
+	require.Equal(t, 2, result.Succeeded)
+	require.Equal(t, 3, result.Skipped)
+	require.Equal(t, workflows.BatchTransferOutcomeSkipped, result.Items[4].Outcome)
+	env.AssertNumberOfCalls(t, "GetBatchTransferPage", 1)
+}
@@ -0,0 +1,98 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"replay-demo/limits"
+	"replay-demo/money"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	ReserveDailyLimitUpdateName = "reserve-daily-limit"
+	ReleaseDailyLimitUpdateName = "release-daily-limit"
+
+	DailyLimitQueryName = "daily-limit"
+
+	// dailyLimitUpdatesPerRun is how many updates a DailyLimitWorkflow run takes before it continues as new.
+	dailyLimitUpdatesPerRun = 500
+	// dailyLimitGracePeriod keeps the limit of a day after the day is over, for transfers of that day still to
+	// release their reservation.
+	dailyLimitGracePeriod = time.Hour
+	// dailyLimitIdleTimeout keeps a DailyLimitWorkflow started after its day is over, only to take a late update, for
+	// that update to reach it.
+	dailyLimitIdleTimeout = time.Minute
+
+	dailyLimitExceededErrorType = "daily-limit-exceeded"
+)
+
+// DailyLimitWorkflow enforces the daily limit of one account on one day, for the transfers of every worker. Transfers
+// reserve out of it with the ReserveDailyLimitUpdateName update and release with the ReleaseDailyLimitUpdateName
+// update, both keyed by reservation ID. It runs until the day is over, continuing as new every
+// dailyLimitUpdatesPerRun updates to keep its history small.
+func DailyLimitWorkflow(ctx workflow.Context, daily limits.Daily) error {
+	end, err := time.Parse(time.DateOnly, daily.Day)
+	if err != nil {
+		return temporal.NewNonRetryableApplicationError(fmt.Sprintf("invalid day: %v", err), "invalid-day", err)
+	}
+	end = end.Add(24*time.Hour + dailyLimitGracePeriod)
+
+	updates := 0
+	if err := workflow.SetQueryHandler(ctx, DailyLimitQueryName, func() (limits.Daily, error) {
+		return daily, nil
+	}); err != nil {
+		return err
+	}
+	if err := workflow.SetUpdateHandler(
+		ctx,
+		ReserveDailyLimitUpdateName,
+		func(ctx workflow.Context, reservationID string, amount money.Amount) error {
+			updates++
+			err := daily.Reserve(reservationID, amount)
+			if errors.Is(err, limits.ErrLimitExceeded) {
+				return temporal.NewNonRetryableApplicationError(err.Error(), dailyLimitExceededErrorType, nil)
+			}
+			return err
+		},
+	); err != nil {
+		return err
+	}
+	if err := workflow.SetUpdateHandler(
+		ctx,
+		ReleaseDailyLimitUpdateName,
+		func(ctx workflow.Context, reservationID string) error {
+			updates++
+			daily.Release(reservationID)
+			return nil
+		},
+	); err != nil {
+		return err
+	}
+
+	for {
+		seen := updates
+		timeout := end.Sub(workflow.Now(ctx))
+		if timeout < dailyLimitIdleTimeout {
+			timeout = dailyLimitIdleTimeout
+		}
+		ok, err := workflow.AwaitWithTimeout(ctx, timeout, func() bool { return updates != seen })
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		if updates >= dailyLimitUpdatesPerRun {
+			return workflow.NewContinueAsNewError(ctx, DailyLimitWorkflow, daily)
+		}
+	}
+}
+
+// dailyLimitWorkflowID is the ID of the DailyLimitWorkflow of accountID on day.
+func dailyLimitWorkflowID(accountID, day string) string {
+	return fmt.Sprintf("daily-limit-%s-%s", accountID, day)
+}
@@ -0,0 +1,73 @@
+package workflows_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+	"replay-demo/limits"
+	"replay-demo/money"
+	"replay-demo/workflows"
+)
+
+func TestDailyLimitWorkflow_AcrossTransfers(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.DailyLimitWorkflow)
+
+	day := env.Now().UTC().Format(time.DateOnly)
+	cb1, cb1Retry, cb2, cb3 := updateCallback{}, updateCallback{}, updateCallback{}, updateCallback{}
+	var used money.Amount
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.ReserveDailyLimitUpdateName, "reserve-1", &cb1, "transfer-1", 60*money.Unit)
+		// a retried reservation is not taken twice
+		env.UpdateWorkflow(workflows.ReserveDailyLimitUpdateName, "reserve-1-retry", &cb1Retry, "transfer-1", 60*money.Unit)
+		env.UpdateWorkflow(workflows.ReserveDailyLimitUpdateName, "reserve-2", &cb2, "transfer-2", 60*money.Unit)
+	}, time.Second)
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.ReleaseDailyLimitUpdateName, "release-1", &updateCallback{}, "transfer-1")
+		env.UpdateWorkflow(workflows.ReserveDailyLimitUpdateName, "reserve-3", &cb3, "transfer-3", 60*money.Unit)
+		value, err := env.QueryWorkflow(workflows.DailyLimitQueryName)
+		require.NoError(t, err)
+		var daily limits.Daily
+		require.NoError(t, value.Get(&daily))
+		used = daily.Used()
+	}, time.Minute)
+
+	env.ExecuteWorkflow(workflows.DailyLimitWorkflow, limits.Daily{AccountID: "my-from-account", Day: day, Limit: 100 * money.Unit})
+
+	// the workflow ends once the day is over
+	require.NoError(t, env.GetWorkflowError())
+	require.NoError(t, cb1.completeErr)
+	require.NoError(t, cb1Retry.completeErr)
+	require.ErrorContains(t, cb2.completeErr, "account my-from-account already used 60.00 of 100.00 on "+day)
+	require.NoError(t, cb3.completeErr)
+	require.Equal(t, 60*money.Unit, used)
+}
+
+func TestDailyLimitWorkflow_ContinuesAsNew(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.DailyLimitWorkflow)
+
+	day := env.Now().UTC().Format(time.DateOnly)
+	env.RegisterDelayedCallback(func() {
+		for i := 0; i < 500; i++ {
+			env.UpdateWorkflow(workflows.ReserveDailyLimitUpdateName, fmt.Sprintf("reserve-%d", i), &updateCallback{},
+				fmt.Sprintf("transfer-%d", i), money.Unit)
+		}
+	}, time.Second)
+
+	env.ExecuteWorkflow(workflows.DailyLimitWorkflow, limits.Daily{AccountID: "my-from-account", Day: day, Limit: 1000 * money.Unit})
+
+	var continueAsNew *workflow.ContinueAsNewError
+	require.ErrorAs(t, env.GetWorkflowError(), &continueAsNew)
+	// the reservations go on to the next run
+	var daily limits.Daily
+	require.NoError(t, converter.GetDefaultDataConverter().FromPayloads(continueAsNew.Input, &daily))
+	require.Equal(t, 500*money.Unit, daily.Used())
+}
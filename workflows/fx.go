@@ -0,0 +1,80 @@
+package workflows
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/text/currency"
+	"replay-demo/money"
+)
+
+// BaseCurrency is the currency daily limits are enforced in, and the currency of requests that don't set one.
+const BaseCurrency = "USD"
+
+// referenceRates are indicative units of each supported currency per BaseCurrency. They are fixed in code so the
+// transfer validator can enforce the daily limit deterministically, and back ReferenceFXProvider.
+var referenceRates = map[string]float64{
+	"USD": 1,
+	"EUR": 0.92,
+	"GBP": 0.79,
+	"CAD": 1.35,
+	"JPY": 147.5,
+}
+
+// FXQuote is the rate at which From is converted into To. It is fetched once by an activity, so the quote a
+// transfer used is locked into its workflow history.
+type FXQuote struct {
+	From     string
+	To       string
+	Rate     float64
+	QuotedAt time.Time
+}
+
+// Convert converts an amount in From into To, rounded to the minor units of To, e.g. to whole yen.
+func (q FXQuote) Convert(amount money.Amount) money.Amount {
+	return amount.Mul(q.Rate).Round(minorUnits(q.To))
+}
+
+// FXProvider quotes exchange rates.
+type FXProvider interface {
+	Quote(ctx context.Context, from, to string) (FXQuote, error)
+}
+
+// ReferenceFXProvider quotes the fixed reference rates.
+type ReferenceFXProvider struct{}
+
+func (ReferenceFXProvider) Quote(ctx context.Context, from, to string) (FXQuote, error) {
+	if !isSupportedCurrency(from) {
+		return FXQuote{}, fmt.Errorf("unsupported currency (%v)", from)
+	}
+	if !isSupportedCurrency(to) {
+		return FXQuote{}, fmt.Errorf("unsupported currency (%v)", to)
+	}
+	return FXQuote{
+		From:     from,
+		To:       to,
+		Rate:     referenceRates[to] / referenceRates[from],
+		QuotedAt: time.Now().UTC(),
+	}, nil
+}
+
+func isSupportedCurrency(currency string) bool {
+	_, ok := referenceRates[currency]
+	return ok
+}
+
+// minorUnits is how many decimals amounts in currencyCode have, e.g. 2 for USD and 0 for JPY.
+func minorUnits(currencyCode string) int {
+	unit, err := currency.ParseISO(currencyCode)
+	if err != nil {
+		return 2
+	}
+	decimals, _ := currency.Standard.Rounding(unit)
+	return min(decimals, 2)
+}
+
+// toBaseCurrency converts an amount at the reference rate, rounded to the cent.
+func toBaseCurrency(amount money.Amount, currency string) money.Amount {
+	return amount.Mul(1 / referenceRates[currency])
+}
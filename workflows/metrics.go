@@ -0,0 +1,55 @@
+package workflows
+
+import (
+	"errors"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// Metrics recorded with the metrics handler of the worker's client, on top of the SDK's. Workflows record them with
+// workflow.GetMetricsHandler, which skips them while replaying, so each is recorded once.
+const (
+	// TransfersStartedMetricName counts transfers whose request was accepted, when they start moving money.
+	TransfersStartedMetricName = "transfers_started"
+	// TransfersSucceededMetricName counts transfers that completed.
+	TransfersSucceededMetricName = "transfers_succeeded"
+	// TransfersCompensatedMetricName counts transfers that failed and whose money was put back.
+	TransfersCompensatedMetricName = "transfers_compensated"
+	// UpdatesRejectedMetricName counts updates rejected by their validator, by update name and reason.
+	UpdatesRejectedMetricName = "updates_rejected"
+	// BatchTransferDurationMetricName is how long batches took, from the start of their first run to their end.
+	BatchTransferDurationMetricName = "batch_transfer_duration"
+)
+
+// updateRejection is the error a request is rejected with, along with the reason its rejection is counted under.
+type updateRejection struct {
+	reason string
+	err    error
+}
+
+func (r updateRejection) Error() string {
+	return r.err.Error()
+}
+
+// rejectFor tags err with the reason it rejects a request for.
+func rejectFor(reason string, err error) error {
+	return updateRejection{reason: reason, err: err}
+}
+
+// countRejection counts the rejection of the updateName update by err, if any, and returns err as the validator's
+// result. Errors not made by rejectFor are counted as "invalid".
+func countRejection(ctx workflow.Context, updateName string, err error) error {
+	if err == nil {
+		return nil
+	}
+	reason := "invalid"
+	var rejection updateRejection
+	if errors.As(err, &rejection) {
+		reason, err = rejection.reason, rejection.err
+	}
+	workflow.GetMetricsHandler(ctx).WithTags(map[string]string{
+		"update": updateName,
+		"reason": reason,
+	}).Counter(UpdatesRejectedMetricName).Inc(1)
+	return err
+}
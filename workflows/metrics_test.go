@@ -0,0 +1,130 @@
+package workflows_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally/v4"
+	sdktally "go.temporal.io/sdk/contrib/tally"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+	"replay-demo/money"
+	"replay-demo/workflows"
+)
+
+// counter sums the counter name of scope across the series whose tags include tags.
+func counter(scope tally.TestScope, name string, tags map[string]string) int64 {
+	var total int64
+	for _, c := range scope.Snapshot().Counters() {
+		if c.Name() != name {
+			continue
+		}
+		match := true
+		for k, v := range tags {
+			match = match && c.Tags()[k] == v
+		}
+		if match {
+			total += c.Value()
+		}
+	}
+	return total
+}
+
+func timerValues(scope tally.TestScope, name string) []time.Duration {
+	var values []time.Duration
+	for _, timer := range scope.Snapshot().Timers() {
+		if timer.Name() == name {
+			values = append(values, timer.Values()...)
+		}
+	}
+	return values
+}
+
+func TestTransferWorkflow_Metrics(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	var suite testsuite.WorkflowTestSuite
+	suite.SetMetricsHandler(sdktally.NewMetricsHandler(scope))
+
+	transfer := func(toAccount string) {
+		env := suite.NewTestWorkflowEnvironment()
+		env.RegisterWorkflow(workflows.TransferWorkflow)
+		env.RegisterActivity(&workflows.TransferActivity{Bank: workflows.NewInMemoryBankGateway(100 * money.Unit)})
+		env.RegisterDelayedCallback(func() {
+			env.UpdateWorkflow(workflows.SetToAccountUpdateName, "to-account", &updateCallback{}, "my-crypto-account")
+			env.UpdateWorkflow(workflows.TransferUpdateName, "invalid", &updateCallback{}, workflows.TransferRequest{
+				FromAccount: "my-from-account",
+				ToAccount:   toAccount,
+				Amount:      -1 * money.Unit,
+			})
+			env.UpdateWorkflow(workflows.TransferUpdateName, "transfer", &updateCallback{}, workflows.TransferRequest{
+				FromAccount: "my-from-account",
+				ToAccount:   toAccount,
+				Amount:      10 * money.Unit,
+			})
+		}, time.Second)
+		env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
+		require.NoError(t, env.GetWorkflowError())
+	}
+	transfer("my-to-account")
+	transfer("my-to-account-piggy-bank") // frozen, so the withdraw is compensated
+
+	require.EqualValues(t, 2, counter(scope, workflows.TransfersStartedMetricName, nil))
+	require.EqualValues(t, 1, counter(scope, workflows.TransfersSucceededMetricName, nil))
+	require.EqualValues(t, 1, counter(scope, workflows.TransfersCompensatedMetricName, nil))
+	require.EqualValues(t, 2, counter(scope, workflows.UpdatesRejectedMetricName, map[string]string{
+		"update": workflows.TransferUpdateName,
+		"reason": "invalid-amount",
+	}))
+	require.EqualValues(t, 2, counter(scope, workflows.UpdatesRejectedMetricName, map[string]string{
+		"update": workflows.SetToAccountUpdateName,
+		"reason": "crypto-account",
+	}))
+}
+
+func TestBatchTransferWorkflow_DurationMetric(t *testing.T) {
+	scope := tally.NewTestScope("", nil)
+	var suite testsuite.WorkflowTestSuite
+	suite.SetMetricsHandler(sdktally.NewMetricsHandler(scope))
+	a := &workflows.TransferActivity{}
+	batch := makeBatch(3)
+	startTime := time.Date(2023, 9, 1, 9, 0, 0, 0, time.UTC)
+
+	env := suite.NewTestWorkflowEnvironment()
+	env.SetStartTime(startTime)
+	env.RegisterWorkflow(workflows.BatchTransferWorkflow)
+	env.RegisterActivity(a)
+	env.OnActivity(a.GetBatchTransferPage, mock.Anything, mock.Anything).Return(
+		workflows.BatchTransferPage{Requests: batch, NextCursor: "3"}, nil)
+	keepPaymentAmounts(env, a)
+	env.OnActivity(a.Transfer, mock.Anything, mock.Anything).After(time.Minute).Return("", nil)
+	env.ExecuteWorkflow(workflows.BatchTransferWorkflow, workflows.BatchTransferOptions{
+		MaxConcurrentTransfers: 1,
+		TransfersPerRun:        3,
+	})
+
+	// the batch isn't done when it continues as new: the next run gets when it started instead
+	var continueAsNew *workflow.ContinueAsNewError
+	require.ErrorAs(t, env.GetWorkflowError(), &continueAsNew)
+	var next workflows.BatchTransferOptions
+	require.NoError(t, converter.GetDefaultDataConverter().FromPayloads(continueAsNew.Input, &next))
+	require.True(t, startTime.Equal(next.StartTime))
+	require.Empty(t, timerValues(scope, workflows.BatchTransferDurationMetricName))
+
+	env = suite.NewTestWorkflowEnvironment()
+	env.SetStartTime(startTime.Add(time.Hour))
+	env.RegisterWorkflow(workflows.BatchTransferWorkflow)
+	env.RegisterActivity(a)
+	env.OnActivity(a.GetBatchTransferPage, mock.Anything, mock.Anything).Return(
+		workflows.BatchTransferPage{Requests: makeBatch(1)}, nil)
+	keepPaymentAmounts(env, a)
+	env.OnActivity(a.Transfer, mock.Anything, mock.Anything).After(time.Minute).Return("", nil)
+	env.ExecuteWorkflow(workflows.BatchTransferWorkflow, next)
+
+	require.NoError(t, env.GetWorkflowError())
+	durations := timerValues(scope, workflows.BatchTransferDurationMetricName)
+	require.Len(t, durations, 1)
+	require.GreaterOrEqual(t, durations[0], time.Hour+time.Minute)
+}
@@ -0,0 +1,102 @@
+package workflows_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	historypb "go.temporal.io/api/history/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+	"replay-demo/workflows"
+)
+
+// Histories in testdata/histories are recorded from real runs with `go run democli/main.go export-history`. Replaying
+// them catches workflow changes that are not backward compatible with running workflows. Each history is replayed with
+// its file name as the workflow ID, so histories of workflows that derive IDs from their own (like child transfers of a
+// batch) must keep the workflow ID as their file name.
+func TestReplayRecordedHistories(t *testing.T) {
+	files, err := filepath.Glob("testdata/histories/*.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			replay(t, file)
+		})
+	}
+}
+
+// Batches started before generated transfers were priced by the GetPaymentAmount local activity replay without it,
+// newer ones with it.
+func TestReplayPaymentAmountVersions(t *testing.T) {
+	for file, pricedTransfers := range map[string]int{
+		"testdata/histories/batch-transfer-parallel.json": 0,
+		"testdata/histories/batch-transfer-priced.json":   4,
+	} {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			localActivities := 0
+			for _, event := range loadHistory(t, file).Events {
+				if event.GetMarkerRecordedEventAttributes().GetMarkerName() == "LocalActivity" {
+					localActivities++
+				}
+			}
+			require.Equal(t, pricedTransfers, localActivities)
+			replay(t, file)
+		})
+	}
+}
+
+// Transfers recorded from the workflow as it was before any change was versioned have no version markers, so they
+// replay down the DefaultVersion path of every change to TransferWorkflow. A change that is not behind
+// workflow.GetVersion breaks them.
+func TestReplayBaselineTransfers(t *testing.T) {
+	files, err := filepath.Glob("testdata/histories/transfer-baseline-*.json")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			for _, event := range loadHistory(t, file).Events {
+				require.NotEqual(t, "Version", event.GetMarkerRecordedEventAttributes().GetMarkerName())
+			}
+			replay(t, file)
+		})
+	}
+}
+
+func loadHistory(t *testing.T, file string) *historypb.History {
+	f, err := os.Open(file)
+	require.NoError(t, err)
+	defer f.Close()
+	history, err := client.HistoryFromJSON(f, client.HistoryJSONOptions{})
+	require.NoError(t, err)
+	return history
+}
+
+// replay replays the history in file against the current workflows, with the file name as the workflow ID.
+func replay(t *testing.T, file string) {
+	replayer := worker.NewWorkflowReplayer()
+	replayer.RegisterWorkflow(workflows.TransferWorkflow)
+	replayer.RegisterWorkflow(workflows.BatchTransferWorkflow)
+	replayer.RegisterWorkflow(workflows.DailyLimitWorkflow)
+
+	require.NoError(t, replayer.ReplayWorkflowHistoryWithOptions(nil, loadHistory(t, file), worker.ReplayWorkflowHistoryOptions{
+		OriginalExecution: workflow.Execution{ID: strings.TrimSuffix(filepath.Base(file), ".json")},
+	}))
+}
+
+func TestReplayDetectsNondeterminism(t *testing.T) {
+	replayer := worker.NewWorkflowReplayer()
+	// a BatchTransferWorkflow that sleeps instead of loading the batch
+	replayer.RegisterWorkflowWithOptions(func(ctx workflow.Context) error {
+		return workflow.Sleep(ctx, time.Second)
+	}, workflow.RegisterOptions{Name: "BatchTransferWorkflow"})
+
+	err := replayer.ReplayWorkflowHistoryFromJSONFile(nil, "testdata/histories/batch-transfer.json")
+	require.Error(t, err)
+}
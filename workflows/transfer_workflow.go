@@ -6,7 +6,10 @@ import (
 	"strings"
 	"time"
 
+	"replay-demo/money"
+
 	"go.temporal.io/sdk/workflow"
+	"golang.org/x/text/currency"
 	"golang.org/x/text/language"
 	"golang.org/x/text/message"
 )
@@ -18,69 +21,243 @@ const (
 
 	TransferUpdateName = "transfer"
 
-	DailyAmountLimit = 100000.0
+	CancelTransferUpdateName = "cancel-transfer"
+
+	TransferStatusQueryName = "transfer-status"
+
+	DailyAmountLimit = 100000 * money.Unit
+
+	DefaultInactivityTimeout = 10 * time.Minute
+
+	// inactivityTimeoutChangeID versions abandoning transfers that got no update for the inactivity timeout.
+	inactivityTimeoutChangeID = "inactivity-timeout"
+	// dailyLimitChangeID versions reserving the daily limit of the from account before the transfer moves money.
+	dailyLimitChangeID = "daily-limit"
+	// conditionalRevertsChangeID versions running Withdraw and Deposit under known activity IDs, so their reverts only
+	// put back what the bank applied.
+	conditionalRevertsChangeID = "conditional-reverts"
+	// minorUnitsChangeID versions rounding converted amounts to the minor units of their currency instead of the cent.
+	minorUnitsChangeID = "minor-units"
+	// cancelledWorkflowChangeID versions putting back what a transfer moved when its workflow is cancelled while the
+	// transfer runs.
+	cancelledWorkflowChangeID = "cancelled-workflow"
 )
 
 type TransferRequest struct {
+	// TransferID identifies the transfer: its TransferWorkflow runs with it as workflow ID, and the request is sent to
+	// it with it as update ID, so a transfer is run at most once. The batch assigns one to requests that don't set it.
+	TransferID  string
+	FromAccount string
+	ToAccount   string
+	Amount      money.Amount
+	// Currency is the ISO 4217 code of Amount, withdrawn from FromAccount. Defaults to BaseCurrency.
+	Currency string
+	// ToCurrency is the currency deposited into ToAccount. Defaults to Currency.
+	ToCurrency string
+}
+
+func (r TransferRequest) withDefaultCurrencies() TransferRequest {
+	if r.Currency == "" {
+		r.Currency = BaseCurrency
+	}
+	if r.ToCurrency == "" {
+		r.ToCurrency = r.Currency
+	}
+	return r
+}
+
+// validate checks the request on its own, before it is sent to a transfer. Currencies must be defaulted.
+func (r TransferRequest) validate() error {
+	if r.FromAccount == "" {
+		return rejectFor("missing-account", fmt.Errorf("from account is not set (%v)", r.FromAccount))
+	}
+	if r.ToAccount == "" {
+		return rejectFor("missing-account", fmt.Errorf("to account is not set (%v)", r.ToAccount))
+	}
+	if !isSupportedCurrency(r.Currency) {
+		return rejectFor("unsupported-currency", fmt.Errorf("unsupported currency (%v)", r.Currency))
+	}
+	if !isSupportedCurrency(r.ToCurrency) {
+		return rejectFor("unsupported-currency", fmt.Errorf("unsupported currency (%v)", r.ToCurrency))
+	}
+	if r.Amount <= 0 {
+		return rejectFor("invalid-amount", fmt.Errorf("invalid transfer amount (%v)", r.Amount))
+	}
+	// the daily limit is in BaseCurrency, converted at the reference rate so validation stays deterministic.
+	if toBaseCurrency(r.Amount, r.Currency) > DailyAmountLimit {
+		return rejectFor("daily-limit", fmt.Errorf("transfer amount (%s) exceeds daily limit (%s)",
+			formatMoney(r.Amount, r.Currency), formatMoney(DailyAmountLimit, BaseCurrency)))
+	}
+	return nil
+}
+
+type TransferStage string
+
+const (
+	TransferStageAwaitingDetails TransferStage = "awaiting-details"
+	TransferStageQuoting         TransferStage = "quoting"
+	TransferStageReservingLimit  TransferStage = "reserving-limit"
+	TransferStageWithdrawing     TransferStage = "withdrawing"
+	TransferStageDepositing      TransferStage = "depositing"
+	TransferStageCompensating    TransferStage = "compensating"
+	TransferStageCancelled       TransferStage = "cancelled"
+	TransferStageCompleted       TransferStage = "completed"
+	// TransferStageRejected means the request passed in TransferWorkflowOptions failed validation.
+	TransferStageRejected TransferStage = "rejected"
+	// TransferStageAbandoned means no update was accepted for the inactivity timeout before the transfer started.
+	TransferStageAbandoned TransferStage = "abandoned"
+	// TransferStageFailed means the transfer failed before any money moved.
+	TransferStageFailed TransferStage = "failed"
+	// TransferStageCompensated means the transfer failed and the money that moved was put back.
+	TransferStageCompensated TransferStage = "compensated"
+	// TransferStageCompensationFailed means at least one compensation failed and the transfer needs manual attention.
+	TransferStageCompensationFailed TransferStage = "compensation-failed"
+)
+
+type TransferWorkflowOptions struct {
+	// InactivityTimeout abandons the transfer when no update is accepted for this long before the transfer starts.
+	// Defaults to DefaultInactivityTimeout.
+	InactivityTimeout time.Duration
+	// Request starts the transfer right away instead of waiting for it to be sent as an update. It is validated like
+	// the TransferUpdateName update, and the workflow ends as rejected if it is invalid.
+	Request *TransferRequest
+}
+
+// TransferStatus is returned by the TransferStatusQueryName query, and by TransferWorkflow once it is done.
+type TransferStatus struct {
+	Stage       TransferStage
 	FromAccount string
 	ToAccount   string
-	Amount      float64
+	Amount      money.Amount
+	Currency    string
+	// DepositAmount is Amount converted into ToCurrency, at FXQuote when the currencies differ.
+	DepositAmount money.Amount
+	ToCurrency    string
+	FXQuote       *FXQuote
+	// Compensations lists the compensations run so far, in the order they ran.
+	Compensations []string
+	LastError     string
 }
 
-func TransferWorkflow(ctx workflow.Context) error {
-	log := workflow.GetLogger(ctx)
+func TransferWorkflow(ctx workflow.Context, options TransferWorkflowOptions) (TransferStatus, error) {
+	if options.InactivityTimeout <= 0 {
+		options.InactivityTimeout = DefaultInactivityTimeout
+	}
+
+	status := TransferStatus{Stage: TransferStageAwaitingDetails}
+	if err := workflow.SetQueryHandler(ctx, TransferStatusQueryName, func() (TransferStatus, error) {
+		return status, nil
+	}); err != nil {
+		return status, err
+	}
 
 	var a *TransferActivity
 	var pendingCompensations []func(workflow.Context) error
 	var transferErr error
-	var transferAttempted, transferDone bool
+	var transferAttempted, transferDone, cancelled bool
+	// moneyMoved tells whether the withdraw succeeded, so a failed transfer can be told from a compensated one.
+	var moneyMoved bool
+	// acceptedUpdates counts accepted updates, resetting the inactivity timer.
+	var acceptedUpdates int
 	transferHandlerFunc := func(ctx workflow.Context, req TransferRequest) error {
+		acceptedUpdates++
 		transferAttempted = true
-		defer func() { transferDone = true }()
+		workflow.GetMetricsHandler(ctx).Counter(TransfersStartedMetricName).Inc(1)
+		defer func() {
+			transferDone = true
+			if transferErr != nil {
+				status.LastError = transferErr.Error()
+			}
+		}()
+		req = req.withDefaultCurrencies()
+		status.FromAccount = req.FromAccount
+		status.ToAccount = req.ToAccount
+		status.Amount = req.Amount
+		status.Currency = req.Currency
+		status.ToCurrency = req.ToCurrency
 
 		ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
 			StartToCloseTimeout: time.Second * 10,
 		})
 
-		// add compensation for withdraw in case it fails.
+		// Quote the exchange rate once, so the rate is locked into history for the deposit and its compensation.
+		depositAmount := req.Amount
+		if req.ToCurrency != req.Currency {
+			status.Stage = TransferStageQuoting
+			var quote FXQuote
+			transferErr = workflow.ExecuteActivity(ctx, a.GetFXQuote, req.Currency, req.ToCurrency).Get(ctx, &quote)
+			if transferErr != nil {
+				return transferErr
+			}
+			status.FXQuote = &quote
+			depositAmount = quote.Convert(req.Amount)
+			// transfers quoted before amounts were rounded to the minor units of their currency deposit to the cent
+			if workflow.GetVersion(ctx, minorUnitsChangeID, workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+				depositAmount = req.Amount.Mul(quote.Rate)
+			}
+		}
+		status.DepositAmount = depositAmount
+
+		// transfers started before the daily limit was enforced across transfers go on without it
+		if workflow.GetVersion(ctx, dailyLimitChangeID, workflow.DefaultVersion, 1) == 1 {
+			status.Stage = TransferStageReservingLimit
+			day := workflow.Now(ctx).UTC().Format(time.DateOnly)
+			baseAmount := toBaseCurrency(req.Amount, req.Currency)
+			transferErr = workflow.ExecuteActivity(ctx, a.ReserveDailyLimit, req.FromAccount, day, baseAmount).Get(ctx, nil)
+			if transferErr != nil {
+				return transferErr
+			}
+			pendingCompensations = append(pendingCompensations, func(ctx workflow.Context) error {
+				status.Compensations = append(status.Compensations, "release-daily-limit")
+				return workflow.ExecuteActivity(ctx, a.ReleaseDailyLimit, req.FromAccount, day).Get(ctx, nil)
+			})
+		}
+
+		// Each step adds its revert before it runs: a step that failed or was cancelled may still have gone through at
+		// the bank, so the revert asks the bank whether it did. Transfers started before the steps had known activity
+		// IDs revert them regardless.
+		var withdrawID, depositID string
+		if workflow.GetVersion(ctx, conditionalRevertsChangeID, workflow.DefaultVersion, 1) == 1 {
+			withdrawID, depositID = "withdraw", "deposit"
+		}
+
+		status.Stage = TransferStageWithdrawing
 		pendingCompensations = append(pendingCompensations, func(ctx workflow.Context) error {
-			return workflow.ExecuteActivity(ctx, a.RevertWithdraw, req.FromAccount, req.Amount).Get(ctx, nil)
+			status.Compensations = append(status.Compensations, "revert-withdraw")
+			return workflow.ExecuteActivity(ctx, a.RevertWithdraw, req.FromAccount, req.Amount, req.Currency, withdrawID).Get(ctx, nil)
 		})
-		transferErr = workflow.ExecuteActivity(ctx, a.Withdraw, req.FromAccount, req.Amount).Get(ctx, nil)
-
+		transferErr = workflow.ExecuteActivity(withActivityID(ctx, withdrawID), a.Withdraw, req.FromAccount, req.Amount, req.Currency).Get(ctx, nil)
 		if transferErr != nil {
 			return transferErr
 		}
+		moneyMoved = true
 
-		// Deposit (adding compensation just in case it fails)
+		status.Stage = TransferStageDepositing
 		pendingCompensations = append(pendingCompensations, func(ctx workflow.Context) error {
-			return workflow.ExecuteActivity(ctx, a.RevertDeposit, req.ToAccount, req.Amount).Get(ctx, nil)
+			status.Compensations = append(status.Compensations, "revert-deposit")
+			return workflow.ExecuteActivity(ctx, a.RevertDeposit, req.ToAccount, depositAmount, req.ToCurrency, depositID).Get(ctx, nil)
 		})
-		transferErr = workflow.ExecuteActivity(ctx, a.Deposit, req.ToAccount, req.Amount).Get(ctx, nil)
-		return transferErr
-	}
-	transferValidator := func(ctx workflow.Context, fromAccount, toAccount string, amount float64) error {
-		if transferAttempted {
-			log.Debug("Rejecting transfer request", "transferAttempted", transferAttempted)
-			return fmt.Errorf("transfer already attempted")
-		}
-		if fromAccount == "" {
-			log.Debug("Rejecting transfer request", "from-account", fromAccount)
-			return fmt.Errorf("from account is not set (%v)", fromAccount)
+		transferErr = workflow.ExecuteActivity(withActivityID(ctx, depositID), a.Deposit, req.ToAccount, depositAmount, req.ToCurrency).Get(ctx, nil)
+		if transferErr != nil {
+			return transferErr
 		}
-		if toAccount == "" {
-			log.Debug("Rejecting transfer request", "to-account", toAccount)
-			return fmt.Errorf("to account is not set (%v)", toAccount)
+		status.Stage = TransferStageCompleted
+		return nil
+	}
+	transferValidator := func(ctx workflow.Context, req TransferRequest) error {
+		req = req.withDefaultCurrencies()
+		if cancelled {
+			workflow.GetLogger(ctx).Debug("Rejecting transfer request", "cancelled", cancelled)
+			return rejectFor("cancelled", fmt.Errorf("transfer cancelled"))
 		}
-		if amount <= 0 {
-			log.Debug("Rejecting transfer request", "transfer-amount", amount)
-			return fmt.Errorf("invalid transfer amount (%v)", amount)
+		if transferAttempted {
+			workflow.GetLogger(ctx).Debug("Rejecting transfer request", "transferAttempted", transferAttempted)
+			return rejectFor("already-attempted", fmt.Errorf("transfer already attempted"))
 		}
-		if amount > DailyAmountLimit {
-			log.Debug("Rejecting transfer request", "transfer-amount", amount)
-			return fmt.Errorf("transfer amount ($%s) exceeds daily limit ($%s)", formatMoney(amount), formatMoney(DailyAmountLimit))
+		if err := req.validate(); err != nil {
+			workflow.GetLogger(ctx).Debug("Rejecting transfer request", "error", err)
+			return err
 		}
-
 		return nil
 	}
 
@@ -89,70 +266,141 @@ func TransferWorkflow(ctx workflow.Context) error {
 		TransferUpdateName,
 		transferHandlerFunc,
 		workflow.UpdateHandlerOptions{Validator: func(ctx workflow.Context, req TransferRequest) error {
-			return transferValidator(ctx, req.FromAccount, req.ToAccount, req.Amount)
+			return countRejection(ctx, TransferUpdateName, transferValidator(ctx, req))
+		}},
+	); err != nil {
+		return status, err
+	}
+
+	if err := workflow.SetUpdateHandlerWithOptions(
+		ctx,
+		CancelTransferUpdateName,
+		func(ctx workflow.Context) error {
+			acceptedUpdates++
+			cancelled = true
+			return nil
+		},
+		workflow.UpdateHandlerOptions{Validator: func(ctx workflow.Context) error {
+			// once the withdraw has started the transfer can only complete or be compensated.
+			if transferAttempted {
+				workflow.GetLogger(ctx).Debug("Rejecting cancel request", "transferAttempted", transferAttempted)
+				return countRejection(ctx, CancelTransferUpdateName, rejectFor("already-started", fmt.Errorf("transfer already started")))
+			}
+			return nil
 		}},
 	); err != nil {
-		return err
+		return status, err
 	}
 
 	// below 3 updates are for page flow
-	var fromAccountID, toAccountID string
 	if err := workflow.SetUpdateHandlerWithOptions(
 		ctx,
 		SetFromAccountUpdateName,
 		func(ctx workflow.Context, accountID string) error {
-			fromAccountID = accountID
+			acceptedUpdates++
+			status.FromAccount = accountID
 			return nil
 		},
 		workflow.UpdateHandlerOptions{Validator: func(ctx workflow.Context, accountID string) error {
 			if strings.Contains(strings.ToLower(accountID), "crypto") {
-				log.Debug("Rejecting from account", "from-account", accountID)
-				return fmt.Errorf("crypto account is not supported (%v)", accountID)
+				workflow.GetLogger(ctx).Debug("Rejecting from account", "from-account", accountID)
+				return countRejection(ctx, SetFromAccountUpdateName,
+					rejectFor("crypto-account", fmt.Errorf("crypto account is not supported (%v)", accountID)))
 			}
 			return nil
 		}},
 	); err != nil {
-		return err
+		return status, err
 	}
 	if err := workflow.SetUpdateHandlerWithOptions(
 		ctx,
 		SetToAccountUpdateName,
 		func(ctx workflow.Context, accountID string) error {
-			toAccountID = accountID
+			acceptedUpdates++
+			status.ToAccount = accountID
 			return nil
 		},
 		workflow.UpdateHandlerOptions{Validator: func(ctx workflow.Context, accountID string) error {
 			if strings.Contains(strings.ToLower(accountID), "crypto") {
-				log.Debug("Rejecting from account", "to-account", accountID)
-				return fmt.Errorf("crypto account is not supported (%v)", accountID)
+				workflow.GetLogger(ctx).Debug("Rejecting to account", "to-account", accountID)
+				return countRejection(ctx, SetToAccountUpdateName,
+					rejectFor("crypto-account", fmt.Errorf("crypto account is not supported (%v)", accountID)))
 			}
 			return nil
 		}},
 	); err != nil {
-		return err
+		return status, err
 	}
 	if err := workflow.SetUpdateHandlerWithOptions(
 		ctx,
 		TransferAmountUpdateName,
-		func(ctx workflow.Context, amount float64) error {
+		func(ctx workflow.Context, amount money.Amount) error {
 			return transferHandlerFunc(ctx, TransferRequest{
-				FromAccount: fromAccountID,
-				ToAccount:   toAccountID,
+				FromAccount: status.FromAccount,
+				ToAccount:   status.ToAccount,
 				Amount:      amount,
 			})
 		},
-		workflow.UpdateHandlerOptions{Validator: func(ctx workflow.Context, amount float64) error {
-			return transferValidator(ctx, fromAccountID, toAccountID, amount)
+		workflow.UpdateHandlerOptions{Validator: func(ctx workflow.Context, amount money.Amount) error {
+			return countRejection(ctx, TransferAmountUpdateName, transferValidator(ctx, TransferRequest{
+				FromAccount: status.FromAccount,
+				ToAccount:   status.ToAccount,
+				Amount:      amount,
+			}))
 		}},
 	); err != nil {
-		return err
+		return status, err
 	}
 
-	// block until transfer is done.
-	workflow.Await(ctx, func() bool { return transferDone })
+	if options.Request != nil {
+		if err := transferValidator(ctx, *options.Request); err != nil {
+			status.Stage = TransferStageRejected
+			status.LastError = err.Error()
+			return status, nil
+		}
+		// the outcome is kept in transferErr, like for the update.
+		_ = transferHandlerFunc(ctx, *options.Request)
+	}
 
-	if transferErr != nil {
-		// execute saga compensations
+	// wait for the transfer to start, abandoning it if the user goes away. Transfers started before they could be
+	// abandoned wait for as long as it takes.
+	if workflow.GetVersion(ctx, inactivityTimeoutChangeID, workflow.DefaultVersion, 1) == workflow.DefaultVersion {
+		workflow.Await(ctx, func() bool { return transferAttempted || cancelled })
+	}
+	for !transferAttempted && !cancelled {
+		seen := acceptedUpdates
+		ok, err := workflow.AwaitWithTimeout(ctx, options.InactivityTimeout, func() bool {
+			return acceptedUpdates != seen || transferAttempted || cancelled
+		})
+		if err != nil {
+			return status, err
+		}
+		if !ok {
+			workflow.GetLogger(ctx).Debug("Abandoning inactive transfer", "inactivity-timeout", options.InactivityTimeout)
+			status.Stage = TransferStageAbandoned
+			return status, nil
+		}
+	}
+
+	// block until transfer is done or cancelled.
+	var cancelErr error
+	if err := workflow.Await(ctx, func() bool { return transferDone || cancelled }); err != nil &&
+		workflow.GetVersion(ctx, cancelledWorkflowChangeID, workflow.DefaultVersion, 1) == 1 {
+		// the workflow was cancelled while the transfer ran: its steps are cancelled too, so wait for the transfer to
+		// give up, put back what it moved, and end as cancelled. Older transfers ended right away, leaving it moved.
+		cancelErr = err
+		ctx, _ = workflow.NewDisconnectedContext(ctx)
+		_ = workflow.Await(ctx, func() bool { return transferDone })
+	}
+	if cancelled {
+		status.Stage = TransferStageCancelled
+		return status, nil
+	}
+
+	if transferErr != nil && len(pendingCompensations) > 0 {
+		// execute saga compensations, even when the transfer failed because its parent batch cancelled it.
+		status.Stage = TransferStageCompensating
+		ctx, _ = workflow.NewDisconnectedContext(ctx)
 		ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
 			StartToCloseTimeout: time.Second * 10,
 		})
@@ -160,13 +408,57 @@ func TransferWorkflow(ctx workflow.Context) error {
 		for i := len(pendingCompensations) - 1; i >= 0; i-- {
 			compensationErrs = append(compensationErrs, pendingCompensations[i](ctx))
 		}
-		return errors.Join(compensationErrs...)
+		if err := errors.Join(compensationErrs...); err != nil {
+			status.Stage = TransferStageCompensationFailed
+			status.LastError = err.Error()
+			return status, err
+		}
+	}
+	if transferErr != nil {
+		// the reverts of the steps that failed ran too, but only a transfer that moved money was compensated.
+		status.Stage = TransferStageFailed
+		if moneyMoved {
+			status.Stage = TransferStageCompensated
+		}
+	}
+	if cancelErr != nil {
+		return status, cancelErr
 	}
 
-	return nil
+	switch status.Stage {
+	case TransferStageCompleted:
+		workflow.GetMetricsHandler(ctx).Counter(TransfersSucceededMetricName).Inc(1)
+	case TransferStageCompensated:
+		workflow.GetMetricsHandler(ctx).Counter(TransfersCompensatedMetricName).Inc(1)
+	}
+	return status, nil
 }
 
-func formatMoney(amount float64) string {
+// withActivityID is ctx with the activities it runs scheduled under id. An empty id keeps the generated IDs.
+func withActivityID(ctx workflow.Context, id string) workflow.Context {
+	if id == "" {
+		return ctx
+	}
+	options := workflow.GetActivityOptions(ctx)
+	options.ActivityID = id
+	return workflow.WithActivityOptions(ctx, options)
+}
+
+// formatMoney formats amount with the symbol and the minor units of its currency, e.g. "$100,000.00" or "¥1,250".
+// Cents are kept even when the currency has no minor units, so the amount shown is never rounded.
+func formatMoney(amount money.Amount, currencyCode string) string {
 	em := message.NewPrinter(language.English)
-	return em.Sprintf("%.2f", amount)
+	unit, err := currency.ParseISO(currencyCode)
+	if err != nil {
+		return amount.String() + " " + currencyCode
+	}
+	sign, cents := "", amount.Cents()
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	formatted := sign + em.Sprint(currency.Symbol(unit)) + em.Sprintf("%d", cents/int64(money.Unit))
+	if minorUnits(currencyCode) > 0 || cents%int64(money.Unit) != 0 {
+		formatted += fmt.Sprintf(".%02d", cents%int64(money.Unit))
+	}
+	return formatted
 }
@@ -1,11 +1,19 @@
 package workflows_test
 
 import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/testsuite"
+	"replay-demo/ledger"
+	"replay-demo/money"
 	"replay-demo/workflows"
 )
 
@@ -33,7 +41,8 @@ func TestTransferWorkflow_Reject(t *testing.T) {
 	var suite testsuite.WorkflowTestSuite
 	env := suite.NewTestWorkflowEnvironment()
 	env.RegisterWorkflow(workflows.TransferWorkflow)
-	a := &workflows.TransferActivity{}
+	bank := workflows.NewInMemoryBankGateway(100 * money.Unit)
+	a := &workflows.TransferActivity{Bank: bank}
 	env.RegisterActivity(a)
 
 	cb1 := updateCallback{}
@@ -43,17 +52,17 @@ func TestTransferWorkflow_Reject(t *testing.T) {
 		env.UpdateWorkflow(workflows.TransferUpdateName, "transaction-id-1", &cb1, workflows.TransferRequest{
 			FromAccount: "my-from-account",
 			ToAccount:   "my-to-account",
-			Amount:      -1, // invalid amount
+			Amount:      -1 * money.Unit, // invalid amount
 		})
 		env.UpdateWorkflow(workflows.TransferUpdateName, "transaction-id-2", &cb2, workflows.TransferRequest{
 			FromAccount: "my-from-account",
 			ToAccount:   "my-to-account",
-			Amount:      1000000, // exceed daily limit
+			Amount:      1000000 * money.Unit, // exceed daily limit
 		})
-	}, time.Nanosecond)
+	}, time.Second)
 
 	// Run workflow
-	env.ExecuteWorkflow(workflows.TransferWorkflow)
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
 
 	require.False(t, cb1.accepted)
 	require.Error(t, cb1.rejectedErr)
@@ -63,16 +72,18 @@ func TestTransferWorkflow_Reject(t *testing.T) {
 	require.Error(t, cb2.rejectedErr)
 	require.Contains(t, cb2.rejectedErr.Error(), "exceeds daily limit")
 
-	// workflow eventually timeout
-	err := env.GetWorkflowResult(nil)
-	require.Error(t, err)
+	// workflow is eventually abandoned
+	var result workflows.TransferStatus
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, workflows.TransferStageAbandoned, result.Stage)
 }
 
 func TestTransferWorkflow_Succeed(t *testing.T) {
 	var suite testsuite.WorkflowTestSuite
 	env := suite.NewTestWorkflowEnvironment()
 	env.RegisterWorkflow(workflows.TransferWorkflow)
-	a := &workflows.TransferActivity{}
+	bank := workflows.NewInMemoryBankGateway(100 * money.Unit)
+	a := &workflows.TransferActivity{Bank: bank}
 	env.RegisterActivity(a)
 
 	cb1 := updateCallback{}
@@ -81,24 +92,31 @@ func TestTransferWorkflow_Succeed(t *testing.T) {
 		env.UpdateWorkflow(workflows.TransferUpdateName, "transaction-id-1", &cb1, workflows.TransferRequest{
 			FromAccount: "my-from-account",
 			ToAccount:   "my-to-account",
-			Amount:      10,
+			Amount:      10 * money.Unit,
 		})
-	}, time.Nanosecond)
+	}, time.Second)
 
 	// Run workflow
-	env.ExecuteWorkflow(workflows.TransferWorkflow)
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
 
 	require.True(t, cb1.accepted)
 	require.NoError(t, cb1.completeErr)
 	err := env.GetWorkflowResult(nil)
 	require.NoError(t, err)
+
+	requireBalance(t, bank, "my-from-account", "USD", 90*money.Unit)
+	requireBalance(t, bank, "my-to-account", "USD", 110*money.Unit)
 }
 
 func TestTransferWorkflow_InvalidToAccount_Compensate(t *testing.T) {
 	var suite testsuite.WorkflowTestSuite
 	env := suite.NewTestWorkflowEnvironment()
 	env.RegisterWorkflow(workflows.TransferWorkflow)
-	a := &workflows.TransferActivity{}
+	bank := workflows.NewInMemoryBankGateway(100 * money.Unit)
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	require.NoError(t, err)
+	defer l.Close()
+	a := &workflows.TransferActivity{Bank: bank, Ledger: l}
 	env.RegisterActivity(a)
 
 	cb1 := updateCallback{}
@@ -107,16 +125,553 @@ func TestTransferWorkflow_InvalidToAccount_Compensate(t *testing.T) {
 		env.UpdateWorkflow(workflows.TransferUpdateName, "transaction-id-1", &cb1, workflows.TransferRequest{
 			FromAccount: "my-from-account",
 			ToAccount:   "my-to-account-piggy-bank",
-			Amount:      10,
+			Amount:      10 * money.Unit,
 		})
-	}, time.Nanosecond)
+	}, time.Second)
 
 	// Run workflow
-	env.ExecuteWorkflow(workflows.TransferWorkflow)
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
 
 	require.True(t, cb1.accepted)
 	require.Error(t, cb1.completeErr)
-	require.Contains(t, cb1.completeErr.Error(), "piggy bank service down")
-	err := env.GetWorkflowResult(nil)
+	require.Contains(t, cb1.completeErr.Error(), "account is frozen")
+	err = env.GetWorkflowResult(nil)
+	require.NoError(t, err)
+
+	// withdraw was compensated
+	requireBalance(t, bank, "my-from-account", "USD", 100*money.Unit)
+	requireBalance(t, bank, "my-to-account-piggy-bank", "USD", 100*money.Unit)
+	entries := l.Entries("default-test-workflow-id", "default-test-run-id")
+	require.Len(t, entries, 2)
+	require.Equal(t, ledger.KindWithdraw, entries[0].Kind)
+	require.Equal(t, ledger.KindRevertWithdraw, entries[1].Kind)
+	for holding, net := range l.Net("default-test-workflow-id", "default-test-run-id") {
+		require.Zero(t, net, holding)
+	}
+}
+
+func TestTransferWorkflow_InsufficientFunds(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	bank := workflows.NewInMemoryBankGateway(100 * money.Unit)
+	bank.SetBalance("my-from-account", "USD", 5*money.Unit)
+	a := &workflows.TransferActivity{Bank: bank}
+	env.RegisterActivity(a)
+
+	cb1 := updateCallback{}
+
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.TransferUpdateName, "transaction-id-1", &cb1, workflows.TransferRequest{
+			FromAccount: "my-from-account",
+			ToAccount:   "my-to-account",
+			Amount:      10 * money.Unit,
+		})
+	}, time.Second)
+
+	// Run workflow
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
+
+	require.True(t, cb1.accepted)
+	require.Error(t, cb1.completeErr)
+	require.Contains(t, cb1.completeErr.Error(), "insufficient funds")
+	var result workflows.TransferStatus
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, workflows.TransferStageFailed, result.Stage)
+
+	// nothing moved, so there was nothing to compensate
+	requireBalance(t, bank, "my-from-account", "USD", 5*money.Unit)
+	requireBalance(t, bank, "my-to-account", "USD", 100*money.Unit)
+}
+
+func requireBalance(t *testing.T, bank workflows.BankGateway, accountID, currency string, expected money.Amount) {
+	t.Helper()
+	balance, err := bank.Balance(context.Background(), accountID, currency)
+	require.NoError(t, err)
+	require.Equal(t, expected, balance)
+}
+
+// timeoutAfterApplyBank lets the first Withdraw and Deposit reach the bank, then reports a retryable error as if the
+// response had been lost, so the activity is retried with the same idempotency key.
+type timeoutAfterApplyBank struct {
+	workflows.BankGateway
+	keys []string
+}
+
+func (b *timeoutAfterApplyBank) Withdraw(ctx context.Context, idempotencyKey, accountID string, amount money.Amount, currency string) error {
+	return b.applyThenTimeout(idempotencyKey, b.BankGateway.Withdraw(ctx, idempotencyKey, accountID, amount, currency))
+}
+
+func (b *timeoutAfterApplyBank) Deposit(ctx context.Context, idempotencyKey, accountID string, amount money.Amount, currency string) error {
+	return b.applyThenTimeout(idempotencyKey, b.BankGateway.Deposit(ctx, idempotencyKey, accountID, amount, currency))
+}
+
+func (b *timeoutAfterApplyBank) applyThenTimeout(idempotencyKey string, err error) error {
+	b.keys = append(b.keys, idempotencyKey)
+	if err == nil && len(b.keys)%2 == 1 {
+		return errors.New("bank response timed out")
+	}
+	return err
+}
+
+func TestTransferWorkflow_RetryIsIdempotent(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	bank := &timeoutAfterApplyBank{BankGateway: workflows.NewInMemoryBankGateway(100 * money.Unit)}
+	a := &workflows.TransferActivity{Bank: bank}
+	env.RegisterActivity(a)
+
+	cb1 := updateCallback{}
+
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.TransferUpdateName, "transaction-id-1", &cb1, workflows.TransferRequest{
+			FromAccount: "my-from-account",
+			ToAccount:   "my-to-account",
+			Amount:      10 * money.Unit,
+		})
+	}, time.Second)
+
+	// Run workflow
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
+
+	require.True(t, cb1.accepted)
+	require.NoError(t, cb1.completeErr)
+	require.NoError(t, env.GetWorkflowResult(nil))
+
+	// both activities were retried once with the same key, and money only moved once
+	require.Len(t, bank.keys, 4)
+	require.Equal(t, bank.keys[0], bank.keys[1])
+	require.Equal(t, bank.keys[2], bank.keys[3])
+	require.NotEqual(t, bank.keys[0], bank.keys[2])
+	require.True(t, strings.HasPrefix(bank.keys[0], "default-test-workflow-id/default-test-run-id/"))
+	requireBalance(t, bank, "my-from-account", "USD", 90*money.Unit)
+	requireBalance(t, bank, "my-to-account", "USD", 110*money.Unit)
+}
+
+func TestTransferWorkflow_RevertsAppliedStepThatFailed(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	bank := workflows.NewInMemoryBankGateway(100 * money.Unit)
+	l, err := ledger.Open(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	require.NoError(t, err)
+	defer l.Close()
+	a := &workflows.TransferActivity{Bank: bank, Ledger: l}
+	env.RegisterActivity(a)
+
+	// the deposit goes through at the bank, but the activity fails as if it had been cancelled
+	env.OnActivity(a.Deposit, mock.Anything, "my-to-account", 10*money.Unit, "USD").Return(func(ctx context.Context, accountID string, amount money.Amount, currency string) error {
+		if err := a.Deposit(ctx, accountID, amount, currency); err != nil {
+			return err
+		}
+		return temporal.NewNonRetryableApplicationError("deposit cancelled", "cancelled", nil)
+	})
+
+	cb1 := updateCallback{}
+
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.TransferUpdateName, "transaction-id-1", &cb1, workflows.TransferRequest{
+			FromAccount: "my-from-account",
+			ToAccount:   "my-to-account",
+			Amount:      10 * money.Unit,
+		})
+	}, time.Second)
+
+	// Run workflow
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
+
+	require.True(t, cb1.accepted)
+	require.ErrorContains(t, cb1.completeErr, "deposit cancelled")
+	require.NoError(t, env.GetWorkflowResult(nil))
+
+	// both steps went through, so both were put back
+	requireBalance(t, bank, "my-from-account", "USD", 100*money.Unit)
+	requireBalance(t, bank, "my-to-account", "USD", 100*money.Unit)
+	for holding, net := range l.Net("default-test-workflow-id", "default-test-run-id") {
+		require.Zero(t, net, holding)
+	}
+}
+
+func TestTransferWorkflow_StatusQuery(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	a := &workflows.TransferActivity{Bank: workflows.NewInMemoryBankGateway(100 * money.Unit)}
+	env.RegisterActivity(a)
+
+	var awaiting workflows.TransferStatus
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.SetFromAccountUpdateName, "from-1", &updateCallback{}, "my-from-account")
+		env.UpdateWorkflow(workflows.SetToAccountUpdateName, "to-1", &updateCallback{}, "my-to-account-piggy-bank")
+	}, time.Second)
+	env.RegisterDelayedCallback(func() {
+		resp, err := env.QueryWorkflow(workflows.TransferStatusQueryName)
+		require.NoError(t, err)
+		require.NoError(t, resp.Get(&awaiting))
+		env.UpdateWorkflow(workflows.TransferAmountUpdateName, "amount-1", &updateCallback{}, 10*money.Unit)
+	}, 2*time.Second)
+
+	// Run workflow
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
+	require.NoError(t, env.GetWorkflowResult(nil))
+
+	require.Equal(t, workflows.TransferStatus{
+		Stage:       workflows.TransferStageAwaitingDetails,
+		FromAccount: "my-from-account",
+		ToAccount:   "my-to-account-piggy-bank",
+	}, awaiting)
+
+	resp, err := env.QueryWorkflow(workflows.TransferStatusQueryName)
 	require.NoError(t, err)
+	var status workflows.TransferStatus
+	require.NoError(t, resp.Get(&status))
+	require.Equal(t, workflows.TransferStageCompensated, status.Stage)
+	require.Equal(t, 10*money.Unit, status.Amount)
+	// the deposit's revert runs too, but finds nothing to take back
+	require.Equal(t, []string{"revert-deposit", "revert-withdraw", "release-daily-limit"}, status.Compensations)
+	require.Contains(t, status.LastError, "account is frozen")
+}
+
+func TestTransferWorkflow_Cancel(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	bank := workflows.NewInMemoryBankGateway(100 * money.Unit)
+	a := &workflows.TransferActivity{Bank: bank}
+	env.RegisterActivity(a)
+
+	cb1 := updateCallback{}
+	cb2 := updateCallback{}
+
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.SetFromAccountUpdateName, "from-1", &updateCallback{}, "my-from-account")
+		env.UpdateWorkflow(workflows.CancelTransferUpdateName, "cancel-1", &cb1)
+		env.UpdateWorkflow(workflows.TransferUpdateName, "transaction-id-1", &cb2, workflows.TransferRequest{
+			FromAccount: "my-from-account",
+			ToAccount:   "my-to-account",
+			Amount:      10 * money.Unit,
+		})
+	}, time.Second)
+
+	// Run workflow
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
+
+	require.True(t, cb1.accepted)
+	require.NoError(t, cb1.completeErr)
+	require.False(t, cb2.accepted)
+	require.ErrorContains(t, cb2.rejectedErr, "transfer cancelled")
+
+	var result workflows.TransferStatus
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, workflows.TransferStageCancelled, result.Stage)
+	require.Equal(t, "my-from-account", result.FromAccount)
+	requireBalance(t, bank, "my-from-account", "USD", 100*money.Unit)
+}
+
+func TestTransferWorkflow_RejectCancelAfterWithdrawStarted(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	a := &workflows.TransferActivity{Bank: workflows.NewInMemoryBankGateway(100 * money.Unit)}
+	env.RegisterActivity(a)
+
+	cb1 := updateCallback{}
+	cb2 := updateCallback{}
+
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.TransferUpdateName, "transaction-id-1", &cb1, workflows.TransferRequest{
+			FromAccount: "my-from-account",
+			ToAccount:   "my-to-account",
+			Amount:      10 * money.Unit,
+		})
+		env.UpdateWorkflow(workflows.CancelTransferUpdateName, "cancel-1", &cb2)
+	}, time.Second)
+
+	// Run workflow
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
+
+	require.True(t, cb1.accepted)
+	require.NoError(t, cb1.completeErr)
+	require.False(t, cb2.accepted)
+	require.ErrorContains(t, cb2.rejectedErr, "transfer already started")
+
+	var result workflows.TransferStatus
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, workflows.TransferStageCompleted, result.Stage)
+}
+
+func TestTransferWorkflow_CancelWorkflowDuringWithdraw(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	bank := workflows.NewInMemoryBankGateway(100 * money.Unit)
+	a := &workflows.TransferActivity{Bank: bank}
+	env.RegisterActivity(a)
+
+	// the withdraw goes through at the bank, but the workflow is cancelled before it reports back
+	env.OnActivity(a.Withdraw, mock.Anything, "my-from-account", 10*money.Unit, "USD").Return(func(ctx context.Context, accountID string, amount money.Amount, currency string) error {
+		if err := a.Withdraw(ctx, accountID, amount, currency); err != nil {
+			return err
+		}
+		env.CancelWorkflow()
+		return nil
+	})
+
+	cb1 := updateCallback{}
+
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.TransferUpdateName, "transaction-id-1", &cb1, workflows.TransferRequest{
+			FromAccount: "my-from-account",
+			ToAccount:   "my-to-account",
+			Amount:      10 * money.Unit,
+		})
+	}, time.Second)
+
+	// Run workflow
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
+
+	require.True(t, cb1.accepted)
+	require.Error(t, cb1.completeErr)
+	var canceledErr *temporal.CanceledError
+	require.ErrorAs(t, env.GetWorkflowError(), &canceledErr)
+
+	// the withdraw was put back, and the deposit never ran
+	requireBalance(t, bank, "my-from-account", "USD", 100*money.Unit)
+	requireBalance(t, bank, "my-to-account", "USD", 100*money.Unit)
+}
+
+func TestTransferWorkflow_AbandonAfterInactivity(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	a := &workflows.TransferActivity{Bank: workflows.NewInMemoryBankGateway(100 * money.Unit)}
+	env.RegisterActivity(a)
+
+	start := env.Now()
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.SetFromAccountUpdateName, "from-1", &updateCallback{}, "my-from-account")
+	}, 4*time.Minute)
+	var stage workflows.TransferStage
+	env.RegisterDelayedCallback(func() {
+		// the accepted update reset the timer, so the transfer is still waiting
+		resp, err := env.QueryWorkflow(workflows.TransferStatusQueryName)
+		require.NoError(t, err)
+		var status workflows.TransferStatus
+		require.NoError(t, resp.Get(&status))
+		stage = status.Stage
+	}, 8*time.Minute)
+
+	// Run workflow, skipping time until the timer fires.
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{InactivityTimeout: 5 * time.Minute})
+
+	require.Equal(t, workflows.TransferStageAwaitingDetails, stage)
+	var result workflows.TransferStatus
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, workflows.TransferStageAbandoned, result.Stage)
+	require.Equal(t, "my-from-account", result.FromAccount)
+	require.Equal(t, 9*time.Minute, env.Now().Sub(start))
+}
+
+func TestTransferWorkflow_DailyLimitExceeded(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	bank := workflows.NewInMemoryBankGateway(workflows.DailyAmountLimit)
+	a := &workflows.TransferActivity{Bank: bank, DailyLimit: workflows.DailyAmountLimit}
+	env.RegisterActivity(a)
+
+	// an earlier transfer today already used most of the limit
+	day := env.Now().UTC().Format(time.DateOnly)
+	env.OnActivity(a.ReserveDailyLimit, mock.Anything, "my-from-account", day, 10*money.Unit).Return(
+		temporal.NewNonRetryableApplicationError("daily limit exceeded", "daily-limit-exceeded", nil)).Once()
+
+	cb1 := updateCallback{}
+
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.TransferUpdateName, "transaction-id-1", &cb1, workflows.TransferRequest{
+			FromAccount: "my-from-account",
+			ToAccount:   "my-to-account",
+			Amount:      10 * money.Unit,
+		})
+	}, time.Second)
+
+	// Run workflow
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
+
+	require.True(t, cb1.accepted)
+	require.ErrorContains(t, cb1.completeErr, "daily limit exceeded")
+	var result workflows.TransferStatus
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, workflows.TransferStageFailed, result.Stage)
+	requireBalance(t, bank, "my-from-account", "USD", workflows.DailyAmountLimit)
+	env.AssertExpectations(t)
+}
+
+func TestTransferWorkflow_CompensationReleasesDailyLimit(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	a := &workflows.TransferActivity{Bank: workflows.NewInMemoryBankGateway(100 * money.Unit), DailyLimit: workflows.DailyAmountLimit}
+	env.RegisterActivity(a)
+
+	day := env.Now().UTC().Format(time.DateOnly)
+	env.OnActivity(a.ReserveDailyLimit, mock.Anything, "my-from-account", day, 10*money.Unit).Return(nil).Once()
+	env.OnActivity(a.ReleaseDailyLimit, mock.Anything, "my-from-account", day).Return(nil).Once()
+
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.TransferUpdateName, "transaction-id-1", &updateCallback{}, workflows.TransferRequest{
+			FromAccount: "my-from-account",
+			ToAccount:   "my-to-account-piggy-bank",
+			Amount:      10 * money.Unit,
+		})
+	}, time.Second)
+
+	// Run workflow
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
+
+	var result workflows.TransferStatus
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, workflows.TransferStageCompensated, result.Stage)
+	env.AssertExpectations(t)
+}
+
+func TestTransferWorkflow_CrossCurrency(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	bank := workflows.NewInMemoryBankGateway(100 * money.Unit)
+	a := &workflows.TransferActivity{Bank: bank, DailyLimit: workflows.DailyAmountLimit}
+	env.RegisterActivity(a)
+
+	// the limit is reserved in the base currency
+	day := env.Now().UTC().Format(time.DateOnly)
+	env.OnActivity(a.ReserveDailyLimit, mock.Anything, "my-from-account", day, 10*money.Unit).Return(nil).Once()
+	quote := workflows.FXQuote{From: "USD", To: "EUR", Rate: 0.9, QuotedAt: env.Now().UTC()}
+	env.OnActivity(a.GetFXQuote, mock.Anything, "USD", "EUR").Return(quote, nil).Once()
+
+	cb1 := updateCallback{}
+	cb2 := updateCallback{}
+
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.TransferUpdateName, "transaction-id-1", &cb1, workflows.TransferRequest{
+			FromAccount: "my-from-account",
+			ToAccount:   "my-to-account",
+			Amount:      10 * money.Unit,
+			Currency:    "EUR",
+			ToCurrency:  "XYZ",
+		})
+		env.UpdateWorkflow(workflows.TransferUpdateName, "transaction-id-2", &cb2, workflows.TransferRequest{
+			FromAccount: "my-from-account",
+			ToAccount:   "my-to-account",
+			Amount:      10 * money.Unit,
+			ToCurrency:  "EUR",
+		})
+	}, time.Second)
+
+	// Run workflow
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
+
+	require.False(t, cb1.accepted)
+	require.ErrorContains(t, cb1.rejectedErr, "unsupported currency (XYZ)")
+	require.True(t, cb2.accepted)
+	require.NoError(t, cb2.completeErr)
+
+	var result workflows.TransferStatus
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, workflows.TransferStageCompleted, result.Stage)
+	require.Equal(t, &quote, result.FXQuote)
+	require.Equal(t, 9*money.Unit, result.DepositAmount)
+	requireBalance(t, bank, "my-from-account", "USD", 90*money.Unit)
+	requireBalance(t, bank, "my-to-account", "EUR", 109*money.Unit)
+	env.AssertExpectations(t)
+}
+
+func TestTransferWorkflow_CrossCurrencyToYen(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	bank := workflows.NewInMemoryBankGateway(100 * money.Unit)
+	a := &workflows.TransferActivity{Bank: bank}
+	env.RegisterActivity(a)
+
+	quote := workflows.FXQuote{From: "USD", To: "JPY", Rate: 147.5, QuotedAt: env.Now().UTC()}
+	env.OnActivity(a.GetFXQuote, mock.Anything, "USD", "JPY").Return(quote, nil).Once()
+
+	cb1 := updateCallback{}
+
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.TransferUpdateName, "transaction-id-1", &cb1, workflows.TransferRequest{
+			FromAccount: "my-from-account",
+			ToAccount:   "my-to-account",
+			Amount:      1001 * money.Cent,
+			ToCurrency:  "JPY",
+		})
+	}, time.Second)
+
+	// Run workflow
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
+
+	require.True(t, cb1.accepted)
+	require.NoError(t, cb1.completeErr)
+
+	// 10.01 dollars are 1476.475 yen, deposited as whole yen
+	var result workflows.TransferStatus
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, 1476*money.Unit, result.DepositAmount)
+	requireBalance(t, bank, "my-to-account", "JPY", 1576*money.Unit)
+}
+
+func TestTransferWorkflow_DailyLimitInBaseCurrency(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	a := &workflows.TransferActivity{Bank: workflows.NewInMemoryBankGateway(100 * money.Unit)}
+	env.RegisterActivity(a)
+
+	cb1 := updateCallback{}
+
+	env.RegisterDelayedCallback(func() {
+		// within the limit in yen, far above it in dollars
+		env.UpdateWorkflow(workflows.TransferUpdateName, "transaction-id-1", &cb1, workflows.TransferRequest{
+			FromAccount: "my-from-account",
+			ToAccount:   "my-to-account",
+			Amount:      90000000 * money.Unit,
+			Currency:    "JPY",
+		})
+	}, time.Second)
+
+	// Run workflow
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
+
+	require.False(t, cb1.accepted)
+	require.ErrorContains(t, cb1.rejectedErr, "transfer amount (¥90,000,000) exceeds daily limit ($100,000.00)")
+}
+
+func TestTransferWorkflow_LegacyFloatAmount(t *testing.T) {
+	var suite testsuite.WorkflowTestSuite
+	env := suite.NewTestWorkflowEnvironment()
+	env.RegisterWorkflow(workflows.TransferWorkflow)
+	bank := workflows.NewInMemoryBankGateway(100 * money.Unit)
+	a := &workflows.TransferActivity{Bank: bank}
+	env.RegisterActivity(a)
+
+	cb1 := updateCallback{}
+
+	// clients built before amounts were fixed-point send float64 amounts
+	env.RegisterDelayedCallback(func() {
+		env.UpdateWorkflow(workflows.SetFromAccountUpdateName, "from-1", &updateCallback{}, "my-from-account")
+		env.UpdateWorkflow(workflows.SetToAccountUpdateName, "to-1", &updateCallback{}, "my-to-account")
+		env.UpdateWorkflow(workflows.TransferAmountUpdateName, "amount-1", &cb1, 37.28491)
+	}, time.Second)
+
+	// Run workflow
+	env.ExecuteWorkflow(workflows.TransferWorkflow, workflows.TransferWorkflowOptions{})
+
+	require.True(t, cb1.accepted)
+	require.NoError(t, cb1.completeErr)
+	var result workflows.TransferStatus
+	require.NoError(t, env.GetWorkflowResult(&result))
+	require.Equal(t, 37*money.Unit+28*money.Cent, result.Amount)
+	requireBalance(t, bank, "my-from-account", "USD", 62*money.Unit+72*money.Cent)
 }